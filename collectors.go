@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// collector is a named group of metrics that can be toggled on the command
+// line with --collector.<name> and --no-collector.<name>. Only collectors
+// marked as stopped are run for containers that are not running.
+type collector struct {
+	name    string
+	enabled *bool
+	stopped bool
+	collect func(c Container) []Metric
+}
+
+var collectors = []collector{
+	new_collector("state", "container state, restart and health metrics", true, true, Container.stateMetrics),
+	new_collector("cpu", "cpuacct controller metrics", true, false, Container.cpuacctMetrics),
+	new_collector("memory", "memory controller metrics", true, false, Container.memoryMetrics),
+	new_collector("pids", "pids controller metrics and process/thread counts", true, false, Container.pidsMetrics),
+	new_collector("blkio", "blkio controller metrics", true, false, Container.blkioMetrics),
+	new_collector("hugetlb", "hugetlb controller metrics", true, false, Container.hugetlbMetrics),
+	new_collector("rdma", "rdma controller metrics", true, false, Container.rdmaMetrics),
+	new_collector("misc", "misc controller metrics", true, false, Container.miscMetrics),
+	new_collector("network", "network interface metrics", true, false, Container.networkMetrics),
+	new_collector("netproto", "TCP/UDP protocol counters and socket states", true, false, Container.protoMetrics),
+	new_collector("connections", "established connections and listening ports", true, false, Container.connectionMetrics),
+	new_collector("fds", "file descriptor usage and limits", true, false, Container.fdMetrics),
+	new_collector("disk", "writable layer and volume disk usage", false, true, Container.diskMetrics),
+	new_collector("processes", "per process name metrics", false, false, Container.processMetrics),
+}
+
+func new_collector(name string, help string, enabled bool, stopped bool, collect func(c Container) []Metric) collector {
+	flag := app.Flag("collector."+name, "collect "+help).Default(strconv.FormatBool(enabled)).Bool()
+	return collector{name, flag, stopped, collect}
+}
+
+func collector_enabled(name string) bool {
+	for _, col := range collectors {
+		if col.name == name {
+			return *col.enabled
+		}
+	}
+	return false
+}
+
+// check_metric_patterns validates the --metric-allow and --metric-deny glob
+// patterns.
+func check_metric_patterns() error {
+	for _, pattern := range append(*MetricAllow, *MetricDeny...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter_metrics drops the metrics that do not match --metric-allow, if
+// given, or that match --metric-deny. The prefix is prepended to the metric
+// names before matching.
+func filter_metrics(prefix string, metrics []Metric) []Metric {
+	if len(*MetricAllow) == 0 && len(*MetricDeny) == 0 {
+		return metrics
+	}
+	var result []Metric
+	for _, m := range metrics {
+		name := prefix + strings.TrimSpace(m.Name)
+		if len(*MetricAllow) > 0 && !match_any(*MetricAllow, name) {
+			continue
+		}
+		if match_any(*MetricDeny, name) {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func match_any(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		matched, _ := path.Match(pattern, name)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reportedCollisions remembers the collisions that were logged, so every
+// collision is only logged once instead of every cycle.
+var reportedCollisions = map[string]string{}
+
+// assign_names returns the metric name of every container, by id. Containers
+// that end up with the same name would overwrite each other's metrics, so they
+// are disambiguated according to --name-collision. The running container
+// that was created first keeps the name, the others get "_" and their short
+// container id ("id") or their position ("index") appended to the last
+// component; "ignore" leaves the names as is.
+func assign_names(hostname string, containers []Container) map[string]string {
+	names := map[string]string{}
+	byName := map[string][]string{}
+	byId := map[string]Container{}
+	for _, c := range containers {
+		byId[c.Id] = c
+		n, err := c.PrimaryName(hostname)
+		if err != nil {
+			log.Printf("An error occurred: %s", err)
+			continue
+		}
+		names[c.Id] = n
+		byName[n] = append(byName[n], c.Id)
+	}
+
+	for n, ids := range byName {
+		if len(ids) < 2 {
+			delete(reportedCollisions, n)
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			a, b := byId[ids[i]], byId[ids[j]]
+			if a.State.Running != b.State.Running {
+				return a.State.Running
+			}
+			if a.Created != b.Created {
+				return a.Created < b.Created
+			}
+			return a.Id < b.Id
+		})
+
+		collision := strings.Join(ids, ",")
+		if reportedCollisions[n] != collision {
+			log.Printf("Containers %s share the name %s, disambiguating using policy '%s'", collision, n, *NameCollision)
+			reportedCollisions[n] = collision
+		}
+
+		for i, id := range ids[1:] {
+			switch *NameCollision {
+			case "id":
+				names[id] = n + "_" + short_id(id)
+			case "index":
+				names[id] = n + "_" + strconv.Itoa(i+1)
+			}
+		}
+	}
+
+	return names
+}
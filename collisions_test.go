@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAssignNames(t *testing.T) {
+	err := compile_name_rules(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := *NameCollision
+	defer func() { *NameCollision = policy }()
+
+	container := func(id string, running bool, created int64) Container {
+		return Container{
+			Id:      id,
+			Name:    "/web",
+			Created: ContainerCreated(created),
+			State:   ContainerState{Running: running},
+		}
+	}
+
+	tests := []struct {
+		policy     string
+		containers []Container
+		expected   map[string]string
+	}{
+		// A single container keeps its name
+		{"id", []Container{container("aaaaaaaaaaaa0000", true, 100)}, map[string]string{
+			"aaaaaaaaaaaa0000": "random.web.main.host",
+		}},
+		// The running container wins over an older stopped one
+		{"id", []Container{container("aaaaaaaaaaaa0000", false, 100), container("bbbbbbbbbbbb0000", true, 200)}, map[string]string{
+			"aaaaaaaaaaaa0000": "random.web.main.host_aaaaaaaaaaaa",
+			"bbbbbbbbbbbb0000": "random.web.main.host",
+		}},
+		// The oldest running container wins
+		{"id", []Container{container("aaaaaaaaaaaa0000", true, 300), container("bbbbbbbbbbbb0000", true, 200)}, map[string]string{
+			"aaaaaaaaaaaa0000": "random.web.main.host_aaaaaaaaaaaa",
+			"bbbbbbbbbbbb0000": "random.web.main.host",
+		}},
+		// Containers created at the same time are ordered by id
+		{"index", []Container{container("cccccccccccc0000", true, 100), container("bbbbbbbbbbbb0000", true, 100), container("aaaaaaaaaaaa0000", false, 50)}, map[string]string{
+			"aaaaaaaaaaaa0000": "random.web.main.host_2",
+			"bbbbbbbbbbbb0000": "random.web.main.host",
+			"cccccccccccc0000": "random.web.main.host_1",
+		}},
+		// Colliding names are kept with --name-collision=ignore
+		{"ignore", []Container{container("aaaaaaaaaaaa0000", true, 100), container("bbbbbbbbbbbb0000", true, 200)}, map[string]string{
+			"aaaaaaaaaaaa0000": "random.web.main.host",
+			"bbbbbbbbbbbb0000": "random.web.main.host",
+		}},
+	}
+
+	for _, test := range tests {
+		*NameCollision = test.policy
+		names := assign_names("host", test.containers)
+		for id, expected := range test.expected {
+			if names[id] != expected {
+				t.Errorf("assign_names() with policy %s named %s %q, want %q", test.policy, id, names[id], expected)
+			}
+		}
+	}
+}
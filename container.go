@@ -1,56 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
-	"os/exec"
-	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
-
-	"github.com/vishvananda/netns"
+	"time"
 )
 
 func (c *Container) GetInfo(proto string, conn string) (err error) {
-	netconn, err := net.Dial(proto, conn)
+	jsonBlob, err := docker_get(proto, conn, "/containers/"+c.Id+"/json")
 	if err != nil {
 		return err
 	}
 
-	id := c.Id
-	url := "/containers/" + id + "/json"
-
-	if *Debug {
-		log.Println("Sending request..." + url)
-	}
-	_, err = netconn.Write([]byte("GET " + url + " HTTP/1.0\r\n\r\n"))
-	if err != nil {
-		return err
-	}
-
-	var result []byte
-
-	var in_bytes = make([]byte, 102400)
-	for {
-		num, err := netconn.Read(in_bytes)
-		result = append(result, in_bytes[0:num]...)
-		if err != nil || num < len(in_bytes) {
-			break
-		}
-	}
-	result = bytes.Trim(result, "\x00")
-	results := bytes.SplitN(result, []byte{'\r', '\n', '\r', '\n'}, 2)
-	jsonBlob := results[1]
-	if *Debug {
-		log.Println("Got response:")
-		log.Println(string(jsonBlob))
-	}
-
 	var container Container
 	err = json.Unmarshal(jsonBlob, &container)
 	*c = container
@@ -73,10 +38,26 @@ func (c Container) blkioFile() string {
 	return fmt.Sprintf("/sys/fs/cgroup/blkio/system.slice/docker-%s.scope/blkio.throttle.io_service_bytes", c.Id)
 }
 
+func (c Container) blkioStatFile(name string) string {
+	return fmt.Sprintf("/sys/fs/cgroup/blkio/system.slice/docker-%s.scope/blkio.%s", c.Id, name)
+}
+
+func (c Container) cgroupFile(controller string, name string) string {
+	return fmt.Sprintf("/sys/fs/cgroup/%s/system.slice/docker-%s.scope/%s", controller, c.Id, name)
+}
+
+func (c Container) cgroupV2File(name string) string {
+	return fmt.Sprintf("/sys/fs/cgroup/system.slice/docker-%s.scope/%s", c.Id, name)
+}
+
 func (c Container) tasksFile() string {
 	return fmt.Sprintf("/sys/fs/cgroup/memory/system.slice/docker-%s.scope/tasks", c.Id)
 }
 
+func (c Container) pidsFile(name string) string {
+	return fmt.Sprintf("/sys/fs/cgroup/pids/system.slice/docker-%s.scope/%s", c.Id, name)
+}
+
 func (c Container) firstPid() (int, error) {
 	data, err := ioutil.ReadFile(c.tasksFile())
 	if err != nil {
@@ -112,90 +93,89 @@ func (c Container) memoryMetrics() []Metric {
 	return key_value_to_metric("memory", string(data))
 }
 
-func (c Container) netMetrics() []Metric {
-	// Lock the OS Thread so we don't accidentally switch namespaces
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	// Save the current network namespace
-	origns, _ := netns.Get()
-	defer origns.Close()
+func (c Container) pidsMetrics() []Metric {
+	var metrics []Metric
+	prefix := "pids"
 
-	// Create a new network namespace
-	pid, _ := c.firstPid()
+	current, err := ioutil.ReadFile(c.pidsFile("pids.current"))
+	if err == nil {
+		metrics = append(metrics, Metric{prefix + ".current", string(current)})
+	}
 
-	newns, _ := netns.GetFromPid(pid)
-	defer newns.Close()
+	// pids.max contains the literal "max" when there is no limit
+	max, err := ioutil.ReadFile(c.pidsFile("pids.max"))
+	if err == nil && strings.TrimSpace(string(max)) != "max" {
+		metrics = append(metrics, Metric{prefix + ".max", string(max)})
+	}
 
-	// Switch to the container namespace
-	netns.Set(newns)
+	procs, err := count_lines(c.pidsFile("cgroup.procs"))
+	if err == nil {
+		metrics = append(metrics, Metric{prefix + ".processes", strconv.Itoa(procs)})
+	}
 
-	data, _ := exec.Command("ip", "-s", "-o", "link").Output()
+	threads, err := count_lines(c.pidsFile("tasks"))
+	if err == nil {
+		metrics = append(metrics, Metric{prefix + ".threads", strconv.Itoa(threads)})
+	}
 
-	// Switch back to the original namespace
-	netns.Set(origns)
-	runtime.UnlockOSThread()
+	return metrics
+}
 
-	int_re, _ := regexp.Compile("^\\d+: ([^:@]+)[:@].*$")
-	prefix := "network"
+// blkioStats are the per-device blkio files that are reported in addition to
+// blkio.throttle.io_service_bytes. The CFQ and BFQ variants only exist when
+// the corresponding scheduler is in use, missing files are skipped.
+var blkioStats = []string{
+	"throttle.io_serviced",
+	"io_service_bytes",
+	"io_serviced",
+	"io_service_time",
+	"io_wait_time",
+	"io_queued",
+	"io_merged",
+	"bfq.io_service_bytes",
+	"bfq.io_serviced",
+	"bfq.io_service_time",
+	"bfq.io_wait_time",
+	"bfq.io_queued",
+	"bfq.io_merged",
+}
 
+func (c Container) blkioMetrics() []Metric {
 	var metrics []Metric
-	var interface_name string
-	var name string
-	var rx []string
-	var tx []string
-
-	for _, link_info := range strings.Split(string(data), "\n") {
-		split_link_info := strings.Split(link_info, "\\")
-		if len(split_link_info) >= 6 {
-			interface_name = int_re.FindStringSubmatch(split_link_info[0])[1]
-			name = prefix + "." + interface_name
-			rx = strings.Fields(split_link_info[3])
-			tx = strings.Fields(split_link_info[5])
-
-			if len(rx) == 6 {
-				rx_packets, _ := strconv.Atoi(rx[1])
-				if rx_packets > 0 {
-					metrics = append(metrics, Metric{name + ".rx.bytes", rx[0]})
-					metrics = append(metrics, Metric{name + ".rx.packets", rx[1]})
-					metrics = append(metrics, Metric{name + ".rx.errors", rx[2]})
-					metrics = append(metrics, Metric{name + ".rx.dropped", rx[3]})
-					metrics = append(metrics, Metric{name + ".rx.overrun", rx[4]})
-					metrics = append(metrics, Metric{name + ".rx.mcast", rx[5]})
-				}
-			}
+	prefix := "blkio"
 
-			if len(tx) == 6 {
-				tx_packets, _ := strconv.Atoi(tx[1])
-				if tx_packets > 0 {
-					metrics = append(metrics, Metric{name + ".tx.bytes", tx[0]})
-					metrics = append(metrics, Metric{name + ".tx.packets", tx[1]})
-					metrics = append(metrics, Metric{name + ".tx.errors", tx[2]})
-					metrics = append(metrics, Metric{name + ".tx.dropped", tx[3]})
-					metrics = append(metrics, Metric{name + ".tx.overrun", tx[4]})
-					metrics = append(metrics, Metric{name + ".tx.mcast", tx[5]})
-				}
-			}
+	data, err := ioutil.ReadFile(c.blkioFile())
+	if err == nil {
+		metrics = append(metrics, blkio_to_metric(prefix, string(data))...)
+	}
+
+	for _, stat := range blkioStats {
+		data, err := ioutil.ReadFile(c.blkioStatFile(stat))
+		if err != nil {
+			continue
 		}
+		metrics = append(metrics, blkio_to_metric(prefix+"."+stat, string(data))...)
 	}
-	return metrics
-}
 
-func (c Container) blkioMetrics() []Metric {
-	data, err := ioutil.ReadFile(c.blkioFile())
-	if err != nil {
-		return nil
+	data, err = ioutil.ReadFile(c.cgroupV2File("io.stat"))
+	if err == nil {
+		metrics = append(metrics, io_stat_to_metric(prefix+".io_stat", string(data))...)
 	}
-	prefix := "blkio"
 
+	return metrics
+}
+
+// blkio_to_metric parses the cgroup v1 blkio format, which has one
+// "<major>:<minor> <Read|Write|Sync|Async|Total> <value>" line per device and
+// operation type, followed by a "Total <value>" line.
+func blkio_to_metric(prefix string, data string) []Metric {
 	var metrics []Metric
 	var split []string
 	var name string
 	var dev string
-	var devA []string
 	var typ string
 	var value string
-	for _, line := range strings.Split(string(data), "\n") {
+	for _, line := range strings.Split(data, "\n") {
 		split = strings.SplitN(line, " ", 3)
 		dev = split[0]
 		if dev != "" {
@@ -203,13 +183,11 @@ func (c Container) blkioMetrics() []Metric {
 				name = prefix + "." + dev
 				value = split[1]
 				metrics = append(metrics, Metric{name, value})
-			} else {
-				dev = grep("^DEVNAME=", "/sys/dev/block/"+dev+"/uevent")
+			} else if len(split) == 3 {
+				dev = device_name(dev)
 				if dev != "" {
-					devA = strings.SplitN(dev, "=", 2)
-					dev = devA[1]
 					typ = split[1]
-					name = prefix + "." + dev + "." + typ
+					name = metric_name(prefix, dev, typ)
 					value = split[2]
 					metrics = append(metrics, Metric{name, value})
 				}
@@ -220,67 +198,119 @@ func (c Container) blkioMetrics() []Metric {
 	return metrics
 }
 
-func (c Container) Metrics() []Metric {
+// io_stat_to_metric parses the cgroup v2 io.stat format, which has one
+// "<major>:<minor> rbytes=<value> wbytes=<value> ..." line per device.
+func io_stat_to_metric(prefix string, data string) []Metric {
 	var metrics []Metric
-	metrics = append(metrics, c.cpuacctMetrics()...)
-	metrics = append(metrics, c.memoryMetrics()...)
-	metrics = append(metrics, c.blkioMetrics()...)
-	metrics = append(metrics, c.netMetrics()...)
-	if *Debug {
-		log.Printf("Metrics: %s", metrics)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		dev := device_name(fields[0])
+		if dev == "" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			split := strings.SplitN(field, "=", 2)
+			if len(split) == 2 {
+				metrics = append(metrics, Metric{metric_name(prefix, dev, split[0]), split[1]})
+			}
+		}
 	}
+
 	return metrics
 }
 
-func (c Container) PrimaryName(hostname string) (string, error) {
-	name := ""
-	if name == "" {
-		alloc_name := find_value(c.Config.Env, "NOMAD_ALLOC_NAME")
-		if len(alloc_name) > 0 {
-			stripPeriodic, _ := regexp.Compile("/periodic-[0-9]+")
-			alloc_name = stripPeriodic.ReplaceAllString(alloc_name, "-periodic")
-			job_name := find_value(c.Config.Env, "NOMAD_JOB_NAME")
-			task_name := find_value(c.Config.Env, "NOMAD_TASK_NAME")
-			task_name = strings.TrimPrefix(task_name, job_name)
-			task_name = strings.TrimPrefix(task_name, "-")
-			if len(task_name) == 0 {
-				task_name = "default"
-			}
-			name = "nomad." + alloc_name + "." + task_name
+// blockDevice is a cached resolution of a "<major>:<minor>" device number.
+// Device numbers are reused when device-mapper or loop devices are recreated,
+// so entries are resolved again after deviceNameTTL.
+type blockDevice struct {
+	devname  string
+	name     string
+	included bool
+	resolved time.Time
+}
+
+const deviceNameTTL = time.Minute
+
+var deviceNames = map[string]blockDevice{}
+
+// device_name resolves a "<major>:<minor>" device number to its kernel name,
+// or its device-mapper name when --blkio-dm-names is set. An empty string is
+// returned for devices that can not be resolved or are filtered out.
+func device_name(dev string) string {
+	d, ok := deviceNames[dev]
+	if ok && time.Since(d.resolved) < deviceNameTTL {
+		if !d.included {
+			return ""
 		}
+		return d.name
 	}
-	if name == "" {
-		name = find_value(c.Config.Env, "SERVICE_NAME")
-		if len(name) > 0 {
-			tag := "default"
-			tags := find_value(c.Config.Env, "SERVICE_TAGS")
-			if len(tags) > 0 {
-				split_tags := strings.SplitN(tags, ",", 2)
-				tag = split_tags[0]
-			}
-			name = "registrator." + name + "." + tag + "." + hostname
-		}
+
+	devname := grep("^DEVNAME=", "/sys/dev/block/"+dev+"/uevent")
+	if devname == "" {
+		delete(deviceNames, dev)
+		return ""
 	}
-	if name == "" {
-		name = c.Name
-		if len(name) > 0 {
-			stripUuid, _ := regexp.Compile("-[0-9a-z]{8}-[0-9a-z]{4}-[0-9a-z]{4}-[0-9a-z]{4}-[0-9a-z]{12}")
-			name = stripUuid.ReplaceAllString(name, "")
-			name = "random." + name + ".main." + hostname
+	devname = strings.SplitN(devname, "=", 2)[1]
+
+	name := devname
+	if *BlkioDmNames {
+		dm, err := ioutil.ReadFile("/sys/dev/block/" + dev + "/dm/name")
+		if err == nil && strings.TrimSpace(string(dm)) != "" {
+			name = "mapper." + strings.TrimSpace(string(dm))
 		}
 	}
-	if name == "" {
-		return "", fmt.Errorf("Could not find a sane name for container '%s'", c.Id)
+
+	if *Debug && (!ok || d.devname != devname || d.name != name) {
+		log.Printf("Resolved block device %s to '%s'", dev, name)
+	}
+	d = blockDevice{devname, name, device_included(name), time.Now()}
+	deviceNames[dev] = d
+	if !d.included {
+		return ""
 	}
+	return d.name
+}
 
-	stripNonWord, _ := regexp.Compile("[^A-Za-z0-9_\\.\\-]+")
-	name = stripNonWord.ReplaceAllString(name, "_")
+func device_included(name string) bool {
+	if *BlkioInclude != nil && !(*BlkioInclude).MatchString(name) {
+		return false
+	}
+	if *BlkioExclude != nil && (*BlkioExclude).MatchString(name) {
+		return false
+	}
+	return true
+}
 
-	removeDoubleIllegalChars, _ := regexp.Compile("__+")
-	name = removeDoubleIllegalChars.ReplaceAllString(name, "_")
+func (c Container) Metrics() []Metric {
+	var metrics []Metric
+	for _, col := range collectors {
+		if !*col.enabled || (!c.State.Running && !col.stopped) {
+			continue
+		}
+		metrics = append(metrics, col.collect(c)...)
+	}
+	metrics = filter_metrics("", metrics)
+	if *Debug {
+		log.Printf("Metrics: %s", metrics)
+	}
+	return metrics
+}
 
-	trimIllegalChars, _ := regexp.Compile("_?\\._?")
-	name = trimIllegalChars.ReplaceAllString(name, ".")
+func (c Container) PrimaryName(hostname string) (string, error) {
+	name, err := render_name(c, hostname)
+	if err != nil {
+		return "", err
+	}
+	name, err = map_name(c, hostname, name)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("Could not find a sane name for container '%s'", c.Id)
+	}
 
-	return name, nil
+	return sanitize_name(name), nil
 }
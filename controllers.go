@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// hugetlbFiles lists the per page size hugetlb files, for cgroup v1 and v2.
+var hugetlbFiles = []string{"usage_in_bytes", "max_usage_in_bytes", "failcnt", "current"}
+
+func (c Container) hugetlbMetrics() []Metric {
+	var metrics []Metric
+	prefix := "hugetlb"
+
+	// The page sizes are only known from the file names, e.g.
+	// hugetlb.2MB.usage_in_bytes or hugetlb.1GB.current
+	sizes := map[string]bool{}
+	for _, pattern := range []string{c.cgroupFile("hugetlb", "hugetlb.*.usage_in_bytes"), c.cgroupV2File("hugetlb.*.current")} {
+		files, _ := filepath.Glob(pattern)
+		for _, f := range files {
+			sizes[strings.SplitN(filepath.Base(f), ".", 3)[1]] = true
+		}
+	}
+
+	for size := range sizes {
+		for _, name := range hugetlbFiles {
+			file := "hugetlb." + size + "." + name
+			data, err := ioutil.ReadFile(c.cgroupFile("hugetlb", file))
+			if err != nil {
+				data, err = ioutil.ReadFile(c.cgroupV2File(file))
+			}
+			if err == nil {
+				metrics = append(metrics, Metric{metric_name(prefix, size, name), string(data)})
+			}
+		}
+
+		data, err := ioutil.ReadFile(c.cgroupV2File("hugetlb." + size + ".events"))
+		if err == nil {
+			metrics = append(metrics, key_value_to_metric(metric_name(prefix, size)+".events", string(data))...)
+		}
+	}
+
+	return metrics
+}
+
+func (c Container) rdmaMetrics() []Metric {
+	data, err := ioutil.ReadFile(c.cgroupFile("rdma", "rdma.current"))
+	if err != nil {
+		data, err = ioutil.ReadFile(c.cgroupV2File("rdma.current"))
+	}
+	if err != nil {
+		return nil
+	}
+
+	// One "<device> hca_handle=<value> hca_object=<value>" line per device
+	var metrics []Metric
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			split := strings.SplitN(field, "=", 2)
+			if len(split) == 2 {
+				metrics = append(metrics, Metric{metric_name("rdma.current", fields[0], split[0]), split[1]})
+			}
+		}
+	}
+	return metrics
+}
+
+func (c Container) miscMetrics() []Metric {
+	data, err := ioutil.ReadFile(c.cgroupV2File("misc.current"))
+	if err != nil {
+		return nil
+	}
+	return key_value_to_metric("misc.current", string(data))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Computing disk usage is expensive for the Docker daemon, so it is only
+// refreshed every --disk-interval and reported from cache in between.
+var (
+	sizesUpdated  time.Time
+	dfUpdated     time.Time
+	containerDisk = map[string]Container{}
+	volumeSizes   = map[string]int64{}
+	lastDf        systemDf
+)
+
+type systemDf struct {
+	LayersSize int64
+	BuildCache []struct {
+		Size int64
+	}
+	Volumes []struct {
+		Name      string
+		UsageData struct {
+			Size     int64
+			RefCount int64
+		}
+	}
+}
+
+// refresh_container_sizes updates the writable layer and root filesystem
+// sizes of the containers, used by the disk collector.
+func refresh_container_sizes(proto string, conn string) {
+	if time.Since(sizesUpdated) < *DiskInterval {
+		return
+	}
+	sizesUpdated = time.Now()
+
+	jsonBlob, err := docker_get(proto, conn, "/containers/json?all=1&size=1")
+	if err != nil {
+		log.Printf("Could not get container disk usage: %s", err)
+		return
+	}
+	var containers []Container
+	err = json.Unmarshal(jsonBlob, &containers)
+	if err != nil {
+		log.Printf("Could not get container disk usage: %s", err)
+		return
+	}
+	containerDisk = map[string]Container{}
+	for _, c := range containers {
+		containerDisk[c.Id] = c
+	}
+}
+
+// refresh_system_df updates the daemon disk usage, used for the volume sizes
+// of the disk collector and the host metrics.
+func refresh_system_df(proto string, conn string) {
+	if time.Since(dfUpdated) < *DiskInterval {
+		return
+	}
+	dfUpdated = time.Now()
+
+	jsonBlob, err := docker_get(proto, conn, "/system/df")
+	if err != nil {
+		log.Printf("Could not get volume disk usage: %s", err)
+		return
+	}
+	var df systemDf
+	err = json.Unmarshal(jsonBlob, &df)
+	if err != nil {
+		log.Printf("Could not get volume disk usage: %s", err)
+		return
+	}
+	lastDf = df
+	volumeSizes = map[string]int64{}
+	for _, v := range df.Volumes {
+		// The daemon reports -1 when the size was not computed
+		if v.UsageData.Size >= 0 {
+			volumeSizes[v.Name] = v.UsageData.Size
+		}
+	}
+}
+
+// anonymousVolume matches the generated names of anonymous volumes.
+var anonymousVolume = regexp.MustCompile("^[0-9a-f]{64}$")
+
+func (c Container) diskMetrics() []Metric {
+	var metrics []Metric
+
+	disk, ok := containerDisk[c.Id]
+	if ok {
+		metrics = append(metrics, Metric{"disk.rw_bytes", strconv.FormatInt(disk.SizeRw, 10)})
+		metrics = append(metrics, Metric{"disk.rootfs_bytes", strconv.FormatInt(disk.SizeRootFs, 10)})
+	}
+
+	for _, m := range c.Mounts {
+		if m.Type != "volume" || anonymousVolume.MatchString(m.Name) {
+			continue
+		}
+		size, ok := volumeSizes[m.Name]
+		if ok {
+			metrics = append(metrics, Metric{metric_name("disk.volume", m.Name) + ".bytes", strconv.FormatInt(size, 10)})
+		}
+	}
+
+	return metrics
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fdMetrics reports the number of open file descriptors of all processes in
+// the container, and the limit and utilisation of the process that is
+// closest to its RLIMIT_NOFILE soft limit.
+func (c Container) fdMetrics() []Metric {
+	pids, err := c.processes()
+	if err != nil {
+		return nil
+	}
+
+	open := 0
+	highest := -1.0
+	var max int64
+	for _, pid := range pids {
+		fds, err := count_entries("/proc/" + pid + "/fd")
+		if err != nil {
+			continue
+		}
+		open += fds
+
+		limit, err := open_files_limit(pid)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		utilisation := float64(fds) / float64(limit)
+		if utilisation > highest {
+			highest = utilisation
+			max = limit
+		}
+	}
+
+	metrics := []Metric{{"fds.open", strconv.Itoa(open)}}
+	if highest >= 0 {
+		metrics = append(metrics, Metric{"fds.max", strconv.FormatInt(max, 10)})
+		metrics = append(metrics, Metric{"fds.utilisation", strconv.FormatFloat(highest, 'f', 4, 64)})
+	}
+	return metrics
+}
+
+// count_entries counts the entries of a directory without calling lstat on
+// every entry, as ioutil.ReadDir would.
+func count_entries(dirname string) (int, error) {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	return len(names), err
+}
+
+// open_files_limit returns the soft RLIMIT_NOFILE of a process, read from the
+// "Max open files" line of /proc/<pid>/limits. It returns 0 when unlimited.
+func open_files_limit(pid string) (int64, error) {
+	line := grep("^Max open files ", "/proc/"+pid+"/limits")
+	fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("Could not find the open files limit of process %s", pid)
+	}
+	if fields[0] == "unlimited" {
+		return 0, nil
+	}
+	return strconv.ParseInt(fields[0], 10, 64)
+}
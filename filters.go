@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+)
+
+// labelFilter matches containers with a label whose value matches a regular
+// expression.
+type labelFilter struct {
+	label string
+	value *regexp.Regexp
+}
+
+var includeLabels, excludeLabels []labelFilter
+
+// compile_filters compiles the label filters given as label=regexp.
+func compile_filters() error {
+	var err error
+	includeLabels, err = compile_label_filters(*IncludeLabel)
+	if err != nil {
+		return err
+	}
+	excludeLabels, err = compile_label_filters(*ExcludeLabel)
+	return err
+}
+
+func compile_label_filters(filters map[string]string) ([]labelFilter, error) {
+	var result []labelFilter
+	for label, expr := range filters {
+		value, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter for label %s: %s", label, err)
+		}
+		result = append(result, labelFilter{label, value})
+	}
+	return result, nil
+}
+
+func (f labelFilter) matches(c Container) bool {
+	value, ok := c.Config.Labels[f.label]
+	return ok && f.value.MatchString(value)
+}
+
+// filter_containers returns the containers that should be reported. A
+// container is skipped when it is a Kubernetes pause container, when it opts
+// out with the --enable-label label set to "false", or when it does not match
+// all include filters or matches any exclude filter.
+func filter_containers(containers []Container) []Container {
+	var result []Container
+	for _, c := range containers {
+		reason := skip_reason(c)
+		if reason != "" {
+			if *Debug {
+				log.Printf("Skipping container %s: %s", c.Id, reason)
+			}
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func skip_reason(c Container) string {
+	if !*KubePause && c.kubernetes().sandbox {
+		return "Kubernetes pause container"
+	}
+	if *EnableLabel != "" && c.Config.Labels[*EnableLabel] == "false" {
+		return "disabled by label " + *EnableLabel
+	}
+
+	// The Nomad job filters only apply to Nomad allocations
+	d := nameData{c: c}
+	for _, f := range []struct {
+		what    string
+		applies bool
+		value   string
+		include **regexp.Regexp
+		exclude **regexp.Regexp
+	}{
+		{"name", true, d.Name(), IncludeName, ExcludeName},
+		{"image", true, d.Image(), IncludeImage, ExcludeImage},
+		{"Nomad job", d.NomadAlloc() != "", d.NomadJob(), IncludeNomad, ExcludeNomad},
+	} {
+		if !f.applies {
+			continue
+		}
+		if *f.include != nil && !(*f.include).MatchString(f.value) {
+			return "does not match the " + f.what + " include filter"
+		}
+		if *f.exclude != nil && (*f.exclude).MatchString(f.value) {
+			return "matches the " + f.what + " exclude filter"
+		}
+	}
+
+	for _, f := range includeLabels {
+		if !f.matches(c) {
+			return "does not match the include filter for label " + f.label
+		}
+	}
+	for _, f := range excludeLabels {
+		if f.matches(c) {
+			return "matches the exclude filter for label " + f.label
+		}
+	}
+	return ""
+}
@@ -6,6 +6,5 @@ require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20190717042225-c3de453c63f4 // indirect
 	github.com/marpaia/graphite-golang v0.0.0-20190519024811-caf161d2c2b1
-	github.com/vishvananda/netns v0.0.0-20190625233234-7109fa855b0f
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
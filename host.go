@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type dockerInfo struct {
+	Containers        int
+	ContainersRunning int
+	ContainersPaused  int
+	ContainersStopped int
+	Images            int
+}
+
+// meminfoFields lists the /proc/meminfo fields that are reported.
+var meminfoFields = []string{"MemTotal", "MemFree", "MemAvailable", "Buffers", "Cached", "SwapTotal", "SwapFree"}
+
+// host_metrics collects the Docker daemon totals and the host load, memory
+// and root cgroup totals.
+func host_metrics(proto string, conn string) []Metric {
+	var metrics []Metric
+
+	jsonBlob, err := docker_get(proto, conn, "/info")
+	if err != nil {
+		log.Printf("Could not get Docker info: %s", err)
+	} else {
+		var info dockerInfo
+		err = json.Unmarshal(jsonBlob, &info)
+		if err != nil {
+			log.Printf("Could not get Docker info: %s", err)
+		} else {
+			metrics = append(metrics,
+				Metric{"docker.containers.total", strconv.Itoa(info.Containers)},
+				Metric{"docker.containers.running", strconv.Itoa(info.ContainersRunning)},
+				Metric{"docker.containers.paused", strconv.Itoa(info.ContainersPaused)},
+				Metric{"docker.containers.stopped", strconv.Itoa(info.ContainersStopped)},
+				Metric{"docker.images.count", strconv.Itoa(info.Images)},
+			)
+		}
+	}
+
+	// The disk usage is refreshed every --disk-interval, see disk.go
+	if !dfUpdated.IsZero() {
+		var buildCache int64
+		for _, b := range lastDf.BuildCache {
+			buildCache += b.Size
+		}
+		metrics = append(metrics,
+			Metric{"docker.images.bytes", strconv.FormatInt(lastDf.LayersSize, 10)},
+			Metric{"docker.build_cache.bytes", strconv.FormatInt(buildCache, 10)},
+		)
+	}
+
+	loadavg, err := ioutil.ReadFile("/proc/loadavg")
+	if err == nil {
+		fields := strings.Fields(string(loadavg))
+		if len(fields) >= 3 {
+			metrics = append(metrics,
+				Metric{"load.1", fields[0]},
+				Metric{"load.5", fields[1]},
+				Metric{"load.15", fields[2]},
+			)
+		}
+	}
+
+	for _, field := range meminfoFields {
+		line := grep("^"+field+":", "/proc/meminfo")
+		split := strings.Fields(line)
+		if len(split) >= 2 {
+			kb, _ := strconv.ParseInt(split[1], 10, 64)
+			metrics = append(metrics, Metric{"memory." + field, strconv.FormatInt(kb*1024, 10)})
+		}
+	}
+
+	metrics = append(metrics, root_cgroup_metrics()...)
+
+	return metrics
+}
+
+// root_cgroup_metrics reports the totals of the root cgroup, for cgroup v1
+// and v2 hierarchies.
+func root_cgroup_metrics() []Metric {
+	var metrics []Metric
+
+	data, err := ioutil.ReadFile("/sys/fs/cgroup/cpu,cpuacct/cpuacct.stat")
+	if err == nil {
+		metrics = append(metrics, key_value_to_metric("cgroup.cpu", string(data))...)
+	}
+	usage, err := ioutil.ReadFile("/sys/fs/cgroup/cpu,cpuacct/cpuacct.usage")
+	if err == nil {
+		metrics = append(metrics, Metric{"cgroup.cpu.usage_ns", string(usage)})
+	}
+	data, err = ioutil.ReadFile("/sys/fs/cgroup/memory/memory.stat")
+	if err == nil {
+		metrics = append(metrics, key_value_to_metric("cgroup.memory", string(data))...)
+	}
+
+	data, err = ioutil.ReadFile("/sys/fs/cgroup/cpu.stat")
+	if err == nil {
+		metrics = append(metrics, key_value_to_metric("cgroup.cpu", string(data))...)
+	}
+
+	return metrics
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+type kubernetesInfo struct {
+	namespace string
+	pod       string
+	container string
+	sandbox   bool
+}
+
+// kubernetes returns the pod information of containers started by dockershim
+// or cri-dockerd, from the io.kubernetes.* labels or, when these are missing,
+// from the k8s_<container>_<pod>_<namespace>_<uid>_<attempt> container name.
+func (c Container) kubernetes() kubernetesInfo {
+	labels := c.Config.Labels
+	k := kubernetesInfo{
+		namespace: labels["io.kubernetes.pod.namespace"],
+		pod:       labels["io.kubernetes.pod.name"],
+		container: labels["io.kubernetes.container.name"],
+		sandbox:   labels["io.kubernetes.docker.type"] == "podsandbox",
+	}
+	if k.pod == "" {
+		split := strings.Split(strings.TrimPrefix(c.Name, "/"), "_")
+		if len(split) == 6 && split[0] == "k8s" {
+			k.container = split[1]
+			k.pod = split[2]
+			k.namespace = split[3]
+		}
+	}
+	if k.container == "POD" {
+		k.sandbox = true
+	}
+	return k
+}
+
+// Pod names are generated from their controller name and a random suffix
+// using the alphabet of k8s.io/apimachinery/pkg/util/rand, e.g.
+// web-5d8f7c9b6-x2k4q for a Deployment and fluentd-x2k4q for a DaemonSet.
+// StatefulSet pods (web-0) have stable names and are left as is.
+var (
+	replicaSetSuffix = regexp.MustCompile("-[bcdfghjklmnpqrstvwxz2456789]{6,10}-[bcdfghjklmnpqrstvwxz2456789]{5}$")
+	podSuffix        = regexp.MustCompile("-[bcdfghjklmnpqrstvwxz2456789]{5}$")
+	cronJobSuffix    = regexp.MustCompile("-[0-9]{8,}-[bcdfghjklmnpqrstvwxz2456789]{5}$")
+)
+
+// pod_template strips the generated suffix of a pod name, so the name stays
+// the same when the pod is replaced. Pods of a CronJob also carry the
+// scheduled time of their job, e.g. backup-27845120-x2k4q.
+func pod_template(pod string) string {
+	if cronJobSuffix.MatchString(pod) {
+		return cronJobSuffix.ReplaceAllString(pod, "")
+	}
+	if replicaSetSuffix.MatchString(pod) {
+		return replicaSetSuffix.ReplaceAllString(pod, "")
+	}
+	return podSuffix.ReplaceAllString(pod, "")
+}
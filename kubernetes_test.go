@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPodTemplate(t *testing.T) {
+	tests := []struct {
+		pod      string
+		expected string
+	}{
+		// Deployment: <deployment>-<replicaset hash>-<suffix>
+		{"web-5d8f7c9b6-x2k4q", "web"},
+		{"api-gateway-7b9c4d8f5b-mwz7p", "api-gateway"},
+		// DaemonSet and Job: <name>-<suffix>
+		{"fluentd-x2k4q", "fluentd"},
+		{"node-exporter-b7n4z", "node-exporter"},
+		// CronJob: <cronjob>-<scheduled minute>-<suffix>
+		{"backup-27845120-x2k4q", "backup"},
+		{"db-backup-27845120-9rvbl", "db-backup"},
+		// StatefulSet pods have stable names
+		{"postgres-0", "postgres-0"},
+		{"kafka-12", "kafka-12"},
+		// Names without a generated suffix are left as is
+		{"standalone", "standalone"},
+		{"web-prod", "web-prod"},
+	}
+
+	for _, test := range tests {
+		result := pod_template(test.pod)
+		if result != test.expected {
+			t.Errorf("pod_template(%q) = %q, want %q", test.pod, result, test.expected)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
@@ -24,11 +26,67 @@ var (
 	GraphitePrefix = app.Flag("prefix", "graphite prefix").Default("containers.metrics").String()
 	Delay          = app.Flag("delay", "delay between metric reports").Default("10000").Int()
 	DockerHost     = app.Flag("dockerhost", "Docker host to contact").Default("unix:/var/run/docker.sock").String()
+	HostMetrics    = app.Flag("host-metrics", "also report Docker daemon and host metrics").Bool()
+	DockerTimeout  = app.Flag("docker-timeout", "timeout of Docker API requests").Default("30s").Duration()
+	AllContainers  = app.Flag("all", "also report containers that are not running").Bool()
+	HostNetwork    = app.Flag("host-network", "how to report containers in the host network namespace (skip, tag or report)").Default("skip").Enum("skip", "tag", "report")
+	NetInclude     = app.Flag("net-include", "only report network interfaces matching this regular expression").Regexp()
+	NetExclude     = app.Flag("net-exclude", "do not report network interfaces matching this regular expression").Regexp()
+	NetHostVeth    = app.Flag("net-host-veth", "report network interfaces using the statistics of their host side veth peer").Bool()
+	DiskInterval   = app.Flag("disk-interval", "interval between disk usage updates").Default("5m").Duration()
+	ProcessNames   = app.Flag("process-names", "maximum number of distinct process names reported per container").Default("20").Int()
+	NameTemplates  = app.Flag("name-template", "template for container names, tried in order before the default naming rules").Strings()
+	ComposeNames   = app.Flag("compose-names", "name Docker Compose containers compose.<project>.<service>.<number>.<hostname>").Bool()
+	GraphiteTags   = app.Flag("graphite-tags", "add Graphite tags derived from container labels to every metric").Bool()
+	TagLabels      = app.Flag("tag-label", "add a Graphite tag with the value of a container label (tag=label)").StringMap()
+	KubePause      = app.Flag("kubernetes-pause", "also report Kubernetes pause (pod sandbox) containers").Bool()
+	NomadAddr      = app.Flag("nomad-addr", "address of the Nomad agent to query for job meta data, e.g. http://127.0.0.1:4646").String()
+	NomadToken     = app.Flag("nomad-token", "Nomad ACL token").Envar("NOMAD_TOKEN").String()
+	NameCollision  = app.Flag("name-collision", "how to disambiguate containers with the same name (id, index or ignore)").Default("id").Enum("id", "index", "ignore")
+	NameMap        = app.Flag("name-map", "JSON file with container name overrides, reloaded when changed").String()
+	Sanitizer      = app.Flag("sanitizer", "metric path rules of the backend (graphite, prometheus or influxdb)").Default("graphite").Enum("graphite", "prometheus", "influxdb")
+	IncludeName    = app.Flag("include-name", "only report containers with a name matching this regular expression").Regexp()
+	ExcludeName    = app.Flag("exclude-name", "do not report containers with a name matching this regular expression").Regexp()
+	IncludeImage   = app.Flag("include-image", "only report containers with an image matching this regular expression").Regexp()
+	ExcludeImage   = app.Flag("exclude-image", "do not report containers with an image matching this regular expression").Regexp()
+	IncludeLabel   = app.Flag("include-label", "only report containers with a label matching a regular expression (label=regexp)").StringMap()
+	ExcludeLabel   = app.Flag("exclude-label", "do not report containers with a label matching a regular expression (label=regexp)").StringMap()
+	IncludeNomad   = app.Flag("include-nomad-job", "only report Nomad allocations of jobs matching this regular expression").Regexp()
+	ExcludeNomad   = app.Flag("exclude-nomad-job", "do not report Nomad allocations of jobs matching this regular expression").Regexp()
+	EnableLabel    = app.Flag("enable-label", "containers with this label set to false are not reported").Default("graphite.enable").String()
+	MetricAllow    = app.Flag("metric-allow", "only report metrics matching one of these glob patterns, e.g. memory.total_*").Strings()
+	MetricDeny     = app.Flag("metric-deny", "do not report metrics matching one of these glob patterns").Strings()
+	BlkioDmNames   = app.Flag("blkio-dm-names", "report device-mapper devices by their /dev/mapper name").Bool()
+	BlkioInclude   = app.Flag("blkio-include", "only report block devices matching this regular expression").Regexp()
+	BlkioExclude   = app.Flag("blkio-exclude", "do not report block devices matching this regular expression").Default("^(loop|ram)[0-9]+$").Regexp()
 )
 
 func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
+	if *GraphiteTags && *Sanitizer == "prometheus" {
+		app.Fatalf("--graphite-tags cannot be used with --sanitizer=prometheus")
+	}
+	if *NomadAddr != "" && !*GraphiteTags {
+		app.Fatalf("--nomad-addr only adds tags, it requires --graphite-tags")
+	}
+
+	err := compile_name_rules(*NameTemplates)
+	app.FatalIfError(err, "Invalid name template")
+
+	err = reload_name_map(*NameMap)
+	app.FatalIfError(err, "Invalid name map")
+
+	err = compile_filters()
+	app.FatalIfError(err, "Invalid container filter")
+
+	err = check_metric_patterns()
+	app.FatalIfError(err, "Invalid metric pattern")
+
+	if *NomadAddr != "" {
+		nomadAPI = new_nomad_client(*NomadAddr, *NomadToken)
+	}
+
 	split := strings.SplitN(*DockerHost, ":", 2)
 	proto := split[0]
 	conn := split[1]
@@ -38,7 +96,9 @@ func main() {
 		log.Fatal("An error has occurred while trying to create a Graphite connector:", err)
 	}
 
-	graphite.Prefix = *GraphitePrefix
+	// The prefix is added by prefix_name, so it is sanitized like the rest
+	// of the path.
+	graphite.Prefix = ""
 
 	if *Debug {
 		log.Printf("Loaded Graphite connection: %#v", graphite)
@@ -58,29 +118,58 @@ func main() {
 				log.Printf("Could not connect graphie: %s", err)
 				panic(err)
 			}
+			// Containers that are removed after they were listed can
+			// not be inspected and are left out
+			inspected := containers[:0]
+			for _, c := range containers {
+				err = c.GetInfo(proto, conn)
+				if err != nil {
+					log.Printf("Could not inspect container %s: %s", c.Id, err)
+					continue
+				}
+				inspected = append(inspected, c)
+			}
+			containers = inspected
+			err = reload_name_map(*NameMap)
+			if err != nil {
+				log.Printf("Could not reload name map, keeping the previous one: %s", err)
+			}
+			containers = filter_containers(containers)
+			names := assign_names(*Hostname, containers)
+			assign_network_namespaces(containers, names)
+			if collector_enabled("disk") {
+				refresh_container_sizes(proto, conn)
+			}
+			if collector_enabled("disk") || *HostMetrics {
+				refresh_system_df(proto, conn)
+			}
 			for _, c := range containers {
-				_ = c.GetInfo(proto, conn)
-				send_container_metrics(*Hostname, c, graphite)
+				n, ok := names[c.Id]
+				if ok {
+					send_container_metrics(*Hostname, n, c, graphite)
+				}
+			}
+			if *HostMetrics {
+				send_host_metrics(*Hostname, proto, conn, graphite)
 			}
 		}
 		time.Sleep(time.Duration(*Delay) * time.Millisecond)
 	}
 }
 
-func send_container_metrics(h string, c Container, graphite *graphite.Graphite) {
-	n, err := c.PrimaryName(h)
-	if err != nil {
-		log.Printf("An error occurred: %s", err)
-		return
-	}
+func send_container_metrics(h string, n string, c Container, graphite *graphite.Graphite) {
 	if *Debug {
 		log.Printf("Container: %s = %s", c.Id, n)
 	}
 	var metric string
 	var m Metric
+	tags := ""
+	if *GraphiteTags {
+		tags = format_tags(c.Tags())
+	}
 	metrics := c.Metrics()
 	for _, m = range metrics {
-		metric = n + "." + m.CleanName()
+		metric = metric_path(prefix_name(n+"."+m.CleanName())) + tags
 		graphite.SimpleSend(metric, m.CleanValue())
 	}
 	if *Debug {
@@ -88,6 +177,16 @@ func send_container_metrics(h string, c Container, graphite *graphite.Graphite)
 	}
 }
 
+func send_host_metrics(h string, proto string, conn string, graphite *graphite.Graphite) {
+	metrics := filter_metrics("host.", host_metrics(proto, conn))
+	for _, m := range metrics {
+		graphite.SimpleSend(metric_path(prefix_name(sanitize_name(h)+".host."+m.CleanName())), m.CleanValue())
+	}
+	if *Debug {
+		log.Printf("Sent %d host metrics for %s", len(metrics), h)
+	}
+}
+
 func (m *Metric) CleanName() string {
 	return strings.TrimSpace(m.Name)
 }
@@ -97,40 +196,59 @@ func (m *Metric) CleanValue() string {
 }
 
 func get_containers(proto string, conn string) ([]Container, error) {
+	url := "/containers/json"
+	if *AllContainers {
+		url += "?all=1"
+	}
+	jsonBlob, err := docker_get(proto, conn, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []Container
+	err = json.Unmarshal(jsonBlob, &containers)
+	return containers, err
+}
+
+// docker_get sends a GET request for url to the Docker API and returns the
+// body of the response.
+func docker_get(proto string, conn string, url string) ([]byte, error) {
 	c, err := net.Dial(proto, conn)
 	if err != nil {
 		return nil, err
 	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(*DockerTimeout))
 
 	if *Debug {
-		log.Println("Sending request...")
+		log.Println("Sending request..." + url)
 	}
-	_, err = c.Write([]byte("GET /containers/json HTTP/1.0\r\n\r\n"))
+	_, err = c.Write([]byte("GET " + url + " HTTP/1.0\r\n\r\n"))
 	if err != nil {
 		return nil, err
 	}
 
-	var result []byte
-
-	var in_bytes = make([]byte, 102400)
-	for {
-		num, err := c.Read(in_bytes)
-		result = append(result, in_bytes[0:num]...)
-		if err != nil || num < len(in_bytes) {
-			break
-		}
+	// The connection is closed by the daemon after an HTTP/1.0 response.
+	// Large responses (/system/df, size=1 listings) arrive in several reads,
+	// so read until EOF rather than stopping at the first short read.
+	result, err := ioutil.ReadAll(c)
+	if err != nil {
+		return nil, err
 	}
-	result = bytes.Trim(result, "\x00")
 	results := bytes.SplitN(result, []byte{'\r', '\n', '\r', '\n'}, 2)
+	if len(results) != 2 {
+		return nil, fmt.Errorf("Invalid response to %s", url)
+	}
+	status := strings.SplitN(string(results[0]), "\r\n", 2)[0]
+	if !strings.Contains(status, " 200 ") {
+		return nil, fmt.Errorf("Request for %s failed: %s", url, status)
+	}
 	jsonBlob := results[1]
 	if *Debug {
 		log.Println("Got response:")
 		log.Println(string(jsonBlob))
 	}
-
-	var containers []Container
-	err = json.Unmarshal(jsonBlob, &containers)
-	return containers, err
+	return jsonBlob, nil
 }
 
 func key_value_to_metric(prefix string, data string) []Metric {
@@ -142,7 +260,7 @@ func key_value_to_metric(prefix string, data string) []Metric {
 		split = strings.SplitN(line, " ", 2)
 		name = split[0]
 		if name != "" {
-			name = prefix + "." + name
+			name = metric_name(prefix, name)
 			value = split[1]
 			metrics = append(metrics, Metric{name, value})
 		}
@@ -179,6 +297,20 @@ func grep(re, filename string) string {
 	}
 }
 
+func count_lines(filename string) (int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func find_value(ss []string, prefix string) (ret string) {
 	for _, s := range ss {
 		if strings.HasPrefix(s, prefix) {
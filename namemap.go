@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// nameMapping is an entry of the --name-map file. All selectors that are set
+// (Name, Image, Label and Value, Match) must match the container. The name is
+// then replaced by the Path template, or rewritten by replacing Match with
+// Replace. For example:
+//
+//	[
+//	  {"name": "^legacy-", "path": "legacy.{{.Name}}.{{.Host}}"},
+//	  {"label": "com.example.team", "value": "^billing$", "path": "billing.{{.Name}}"},
+//	  {"match": "^random\\.old-(.*)$", "replace": "renamed.$1"}
+//	]
+type nameMapping struct {
+	Name    string
+	Image   string
+	Label   string
+	Value   string
+	Match   string
+	Path    string
+	Replace string
+
+	name  *regexp.Regexp
+	image *regexp.Regexp
+	value *regexp.Regexp
+	match *regexp.Regexp
+	path  *template.Template
+}
+
+var (
+	nameMappings []nameMapping
+	nameMapTime  time.Time
+)
+
+// reload_name_map loads the name map file when it was modified since it was
+// last loaded. The previous mappings are kept when the file is invalid.
+func reload_name_map(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(nameMapTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var mappings []nameMapping
+	err = json.Unmarshal(data, &mappings)
+	if err != nil {
+		return err
+	}
+	for i := range mappings {
+		err = mappings[i].compile()
+		if err != nil {
+			return fmt.Errorf("Invalid mapping %d: %s", i+1, err)
+		}
+	}
+
+	nameMappings = mappings
+	nameMapTime = info.ModTime()
+	if *Debug {
+		log.Printf("Loaded %d name mappings from %s", len(mappings), filename)
+	}
+	return nil
+}
+
+func (m *nameMapping) compile() error {
+	var err error
+	for _, r := range []struct {
+		expr   string
+		target **regexp.Regexp
+	}{
+		{m.Name, &m.name},
+		{m.Image, &m.image},
+		{m.Value, &m.value},
+		{m.Match, &m.match},
+	} {
+		if r.expr == "" {
+			continue
+		}
+		*r.target, err = regexp.Compile(r.expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Path != "" {
+		m.path, err = template.New("path").Funcs(nameFuncs).Parse(m.Path)
+		if err != nil {
+			return err
+		}
+	} else if m.match == nil {
+		return fmt.Errorf("Either path or match and replace must be set")
+	}
+	return nil
+}
+
+func (m nameMapping) matches(d nameData, name string) bool {
+	if m.name != nil && !m.name.MatchString(d.Name()) {
+		return false
+	}
+	if m.image != nil && !m.image.MatchString(d.Image()) {
+		return false
+	}
+	if m.Label != "" {
+		value, ok := d.c.Config.Labels[m.Label]
+		if !ok || (m.value != nil && !m.value.MatchString(value)) {
+			return false
+		}
+	}
+	if m.match != nil && !m.match.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// reportedMappings remembers the invalid mapping results that were logged,
+// so they are only logged once.
+var reportedMappings = map[string]bool{}
+
+// map_name applies the first matching name mapping to the name of a
+// container. Mappings that produce a name with empty components are skipped,
+// like the naming rules.
+func map_name(c Container, hostname string, name string) (string, error) {
+	d := nameData{c, hostname}
+	for _, m := range nameMappings {
+		if !m.matches(d, name) {
+			continue
+		}
+		var mapped string
+		if m.path == nil {
+			mapped = m.match.ReplaceAllString(name, m.Replace)
+		} else {
+			var buf bytes.Buffer
+			err := m.path.Execute(&buf, d)
+			if err != nil {
+				return "", err
+			}
+			mapped = buf.String()
+		}
+		mapped = strings.TrimSpace(mapped)
+		if valid_name(mapped) {
+			return mapped, nil
+		}
+		if !reportedMappings[name+" "+mapped] {
+			log.Printf("Name mapping of %s produced the invalid name '%s', skipping it", name, mapped)
+			reportedMappings[name+" "+mapped] = true
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// defaultNameRules are tried after the --name-template rules: Kubernetes
+// pods, Swarm tasks, Nomad allocations and registrator services, and finally
+// the historical random.<name> scheme for all other containers.
+var defaultNameRules = []string{
+	`{{with .KubePodTemplate}}kubernetes.{{$.KubeNamespace}}.{{.}}.{{$.KubeContainer}}.{{$.Host}}{{end}}`,
+	`{{with .SwarmService}}swarm.{{$.SwarmStack | default "default"}}.{{.}}.{{$.SwarmSlot}}{{end}}`,
+	`{{with .NomadAlloc}}nomad.{{.}}.{{$.NomadTask}}{{end}}`,
+	`{{with .Env "SERVICE_NAME"}}registrator.{{.}}.{{$.Env "SERVICE_TAGS" | field "," 0 | default "default"}}.{{$.Host}}{{end}}`,
+	`{{with .Name}}random.{{regexReplace "-[0-9a-z]{8}-[0-9a-z]{4}-[0-9a-z]{4}-[0-9a-z]{4}-[0-9a-z]{12}" "" .}}.main.{{$.Host}}{{end}}`,
+}
+
+// composeNameRule names Docker Compose services after their project, service
+// and container number. It is only used with --compose-names, since these
+// containers were historically named after the container name.
+const composeNameRule = `{{with .Label "com.docker.compose.project"}}compose.{{.}}.{{$.Label "com.docker.compose.service"}}.{{$.Label "com.docker.compose.container-number" | default "1"}}.{{$.Host}}{{end}}`
+
+var nameRules []*template.Template
+
+var nameFuncs = template.FuncMap{
+	"regexReplace": func(pattern string, replacement string, s string) (string, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, replacement), nil
+	},
+	"trimPrefix": func(prefix string, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"trimSuffix": func(suffix string, s string) string {
+		return strings.TrimSuffix(s, suffix)
+	},
+	"default": func(def string, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+	"field": func(sep string, i int, s string) string {
+		fields := strings.Split(s, sep)
+		if i < 0 || i >= len(fields) {
+			return ""
+		}
+		return fields[i]
+	},
+	"lower": strings.ToLower,
+}
+
+// nameData is the data available to naming templates, e.g.
+//
+//	{{.Label "com.docker.compose.project"}}.{{.Name}}.{{.Host}}
+type nameData struct {
+	c    Container
+	Host string
+}
+
+// Name returns the container name, without the leading slash.
+func (d nameData) Name() string {
+	return strings.TrimPrefix(d.c.Name, "/")
+}
+
+func (d nameData) Id() string {
+	return d.c.Id
+}
+
+func (d nameData) ShortId() string {
+	return short_id(d.c.Id)
+}
+
+// short_id returns the 12 character abbreviation of a container id, as used
+// by the docker command line.
+func short_id(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Image returns the image name the container was created from.
+func (d nameData) Image() string {
+	if d.c.Config.Image != "" {
+		return d.c.Config.Image
+	}
+	return d.c.Image
+}
+
+func (d nameData) Env(key string) string {
+	return find_value(d.c.Config.Env, key+"=")
+}
+
+func (d nameData) Label(key string) string {
+	return d.c.Config.Labels[key]
+}
+
+func (d nameData) KubeNamespace() string {
+	return d.c.kubernetes().namespace
+}
+
+func (d nameData) KubePod() string {
+	return d.c.kubernetes().pod
+}
+
+func (d nameData) KubePodTemplate() string {
+	return pod_template(d.c.kubernetes().pod)
+}
+
+func (d nameData) KubeContainer() string {
+	return d.c.kubernetes().container
+}
+
+func (d nameData) SwarmStack() string {
+	return d.c.swarm().stack
+}
+
+func (d nameData) SwarmService() string {
+	return d.c.swarm().service
+}
+
+func (d nameData) SwarmSlot() string {
+	return d.c.swarm().slot
+}
+
+func (d nameData) NomadNamespace() string {
+	return d.c.nomad().namespace
+}
+
+func (d nameData) NomadRegion() string {
+	return d.c.nomad().region
+}
+
+func (d nameData) NomadDC() string {
+	return d.c.nomad().dc
+}
+
+func (d nameData) NomadJob() string {
+	return d.c.nomad().job
+}
+
+func (d nameData) NomadGroup() string {
+	return d.c.nomad().group
+}
+
+func (d nameData) NomadAlloc() string {
+	return d.c.nomad().alloc
+}
+
+func (d nameData) NomadTask() string {
+	return d.c.nomad().task
+}
+
+// compile_name_rules parses the naming templates given on the command line,
+// followed by the Docker Compose rule when enabled and the default rules.
+func compile_name_rules(templates []string) error {
+	var rules []string
+	rules = append(rules, templates...)
+	if *ComposeNames {
+		rules = append(rules, composeNameRule)
+	}
+	rules = append(rules, defaultNameRules...)
+
+	nameRules = nil
+	for _, text := range rules {
+		t, err := template.New("name").Funcs(nameFuncs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return err
+		}
+		nameRules = append(nameRules, t)
+	}
+	return nil
+}
+
+// render_name returns the result of the first naming rule that produces a
+// name without empty path components, or an empty string if none does.
+func render_name(c Container, hostname string) (string, error) {
+	data := nameData{c, hostname}
+	for _, t := range nameRules {
+		var buf bytes.Buffer
+		err := t.Execute(&buf, data)
+		if err != nil {
+			return "", err
+		}
+		name := strings.TrimSpace(buf.String())
+		if valid_name(name) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+func valid_name(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, component := range strings.Split(name, ".") {
+		if component == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Network namespaces are shared between containers (--net=container:<id>,
+// pods, Nomad task groups) and with the host (--net=host). The counters of a
+// namespace are reported once per cycle, by its owning container.
+var (
+	hostNetns      string
+	netnsOwners    = map[string]string{}
+	netnsOwnerName = map[string]string{}
+	netnsMembers   = map[string]int{}
+	containerNetns = map[string]string{}
+	netnsSockets   = map[string][]socket{}
+)
+
+// netnsInode returns the identifier of the container's network namespace, as
+// found in /proc/<pid>/ns/net, e.g. "net:[4026531992]".
+func (c Container) netnsInode() (string, error) {
+	filename, err := c.procFile("ns/net")
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(filename)
+}
+
+// netnsSandbox returns whether the container only holds the network namespace
+// of a group: Kubernetes pause containers and the nomad_init_<alloc id>
+// containers of Nomad bridge networking. They are replaced with every pod or
+// allocation, so they are poor owners.
+func (c Container) netnsSandbox() bool {
+	return c.kubernetes().sandbox || strings.HasPrefix(strings.TrimPrefix(c.Name, "/"), "nomad_init_")
+}
+
+// assign_network_namespaces determines the network namespace of every
+// container and elects an owner for each namespace. The container that holds
+// the namespace, i.e. the target of --net=container:<id>, is the owner unless
+// it is a sandbox. Otherwise, e.g. when the pause container of a pod is not
+// reported, the member with the lowest metric name is elected, so the owner
+// survives restarts of the containers in the group.
+func assign_network_namespaces(containers []Container, names map[string]string) {
+	hostNetns, _ = os.Readlink("/proc/1/ns/net")
+	netnsOwners = map[string]string{}
+	netnsOwnerName = map[string]string{}
+	netnsMembers = map[string]int{}
+	containerNetns = map[string]string{}
+	netnsSockets = map[string][]socket{}
+
+	sorted := make([]Container, len(containers))
+	copy(sorted, containers)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.netnsSandbox() != b.netnsSandbox() {
+			return b.netnsSandbox()
+		}
+		// Containers without a name are not reported
+		if (names[a.Id] == "") != (names[b.Id] == "") {
+			return names[b.Id] == ""
+		}
+		aJoined := strings.HasPrefix(a.HostConfig.NetworkMode, "container:")
+		bJoined := strings.HasPrefix(b.HostConfig.NetworkMode, "container:")
+		if aJoined != bJoined {
+			return bJoined
+		}
+		if names[a.Id] != names[b.Id] {
+			return names[a.Id] < names[b.Id]
+		}
+		return a.Id < b.Id
+	})
+
+	for _, c := range sorted {
+		if !c.State.Running {
+			continue
+		}
+		inode, err := c.netnsInode()
+		if err != nil {
+			if *Debug {
+				log.Printf("Could not determine network namespace of %s: %s", c.Id, err)
+			}
+			continue
+		}
+		containerNetns[c.Id] = inode
+		netnsMembers[inode]++
+		owner, ok := netnsOwners[inode]
+		if !ok {
+			netnsOwners[inode] = c.Id
+			netnsOwnerName[inode] = names[c.Id]
+		} else if *Debug {
+			log.Printf("Container %s shares network namespace %s with %s", c.Id, inode, owner)
+		}
+	}
+}
+
+// network_owner returns the metric name of the container that reports the
+// network namespace of a container, if that is another container.
+func network_owner(id string) string {
+	inode, ok := containerNetns[id]
+	if !ok || inode == hostNetns || netnsOwners[inode] == id {
+		return ""
+	}
+	return netnsOwnerName[inode]
+}
+
+// reports_network returns whether the container reports the metrics of its
+// network namespace: it owns the namespace, and it is not the host namespace
+// unless --host-network=report.
+func reports_network(c Container) bool {
+	inode, ok := containerNetns[c.Id]
+	if !ok {
+		return false
+	}
+	if inode == hostNetns && *HostNetwork != "report" {
+		return false
+	}
+	return netnsOwners[inode] == c.Id
+}
+
+// networkMetrics collects the metrics of the container's network namespace,
+// if the container owns it. Other members of the namespace report
+// network.shared, and are tagged with the name of the owner. Containers in the
+// host namespace are handled according to --host-network.
+func (c Container) networkMetrics() []Metric {
+	inode, ok := containerNetns[c.Id]
+	if !ok {
+		return nil
+	}
+	if inode == hostNetns {
+		switch *HostNetwork {
+		case "skip":
+			return nil
+		case "tag":
+			return []Metric{{"network.host", "1"}}
+		}
+	}
+	if netnsOwners[inode] != c.Id {
+		return []Metric{{"network.shared", "1"}}
+	}
+
+	metrics := []Metric{{"network.namespace_members", strconv.Itoa(netnsMembers[inode])}}
+
+	net, err := c.netMetrics()
+	if err != nil {
+		log.Printf("Could not collect network metrics for %s: %s", c.Id, err)
+	}
+	metrics = append(metrics, net...)
+
+	return metrics
+}
+
+// protoMetrics collects the protocol counters of the container's network
+// namespace, if the container reports it.
+func (c Container) protoMetrics() []Metric {
+	if !reports_network(c) {
+		return nil
+	}
+	metrics, err := c.protoStatistics(c.sharedTcpSockets())
+	if err != nil {
+		log.Printf("Could not collect protocol metrics for %s: %s", c.Id, err)
+	}
+	return metrics
+}
+
+// connectionMetrics collects the connection and listening port inventory of
+// the container's network namespace, if the container reports it.
+func (c Container) connectionMetrics() []Metric {
+	if !reports_network(c) {
+		return nil
+	}
+	metrics, err := c.connectionStatistics(c.sharedTcpSockets())
+	if err != nil {
+		log.Printf("Could not collect connection metrics for %s: %s", c.Id, err)
+	}
+	return metrics
+}
+
+// sharedTcpSockets returns the TCP sockets of the container's network
+// namespace. They are parsed once per cycle and shared by the protocol and
+// connection metrics.
+func (c Container) sharedTcpSockets() []socket {
+	inode := containerNetns[c.Id]
+	tcp, ok := netnsSockets[inode]
+	if ok {
+		return tcp
+	}
+	tcp, err := c.tcpSockets()
+	if err != nil {
+		log.Printf("Could not collect TCP sockets for %s: %s", c.Id, err)
+	}
+	netnsSockets[inode] = tcp
+	return tcp
+}
+
+// procFile returns the path of a file in the proc filesystem of the
+// container's first process. Files under /proc/<pid>/net reflect the network
+// namespace of that process, so there is no need to enter the namespace.
+func (c Container) procFile(name string) (string, error) {
+	pid, err := c.firstPid()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/proc/%d/%s", pid, name), nil
+}
+
+// netDevReceive and netDevTransmit name the columns of /proc/net/dev that are
+// reported, indexed by their position in the receive and transmit groups.
+// The names follow the kernel counters: /proc/net/dev has no separate
+// rx_over_errors column, so the fifo counter is reported as rx.fifo.
+var netDevReceive = map[int]string{
+	0: "bytes",
+	1: "packets",
+	2: "errors",
+	3: "dropped",
+	4: "fifo",
+	7: "mcast",
+}
+
+var netDevTransmit = map[int]string{
+	0: "bytes",
+	1: "packets",
+	2: "errors",
+	3: "dropped",
+	5: "collisions",
+	6: "carrier",
+}
+
+func (c Container) netMetrics() ([]Metric, error) {
+	filename, err := c.procFile("net/dev")
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if *NetHostVeth {
+		return c.vethMetrics(string(data))
+	}
+	return net_dev_to_metric("network", string(data))
+}
+
+func interface_included(name string) bool {
+	if *NetInclude != nil && !(*NetInclude).MatchString(name) {
+		return false
+	}
+	if *NetExclude != nil && (*NetExclude).MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// vethStatistics maps the host side statistics of a veth peer to the
+// container side metric names; what the host receives the container sent.
+var vethStatistics = []struct {
+	file   string
+	metric string
+}{
+	{"tx_bytes", "rx.bytes"},
+	{"tx_packets", "rx.packets"},
+	{"tx_errors", "rx.errors"},
+	{"tx_dropped", "rx.dropped"},
+	{"rx_bytes", "tx.bytes"},
+	{"rx_packets", "tx.packets"},
+	{"rx_errors", "tx.errors"},
+	{"rx_dropped", "tx.dropped"},
+}
+
+// vethWarned remembers that the missing host network namespace was logged.
+var vethWarned bool
+
+// vethMetrics reports the container's interfaces using the statistics of
+// their host side veth peers. The peer is the host interface whose ifindex
+// equals the iflink of the container interface, which is read through the
+// sysfs mounted in the container's root. The host side is read from our own
+// /sys/class/net, so this requires running in the host network namespace;
+// otherwise the container side statistics are reported.
+func (c Container) vethMetrics(data string) ([]Metric, error) {
+	self, _ := os.Readlink("/proc/self/ns/net")
+	if self != hostNetns {
+		if !vethWarned {
+			log.Printf("--net-host-veth requires the host network namespace, reporting container side statistics")
+			vethWarned = true
+		}
+		return net_dev_to_metric("network", data)
+	}
+
+	root, err := c.procFile("root")
+	if err != nil {
+		return nil, err
+	}
+	peers, err := host_interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []Metric
+	for _, interface_name := range net_dev_interfaces(data) {
+		if !interface_included(interface_name) {
+			continue
+		}
+		iflink, err := ioutil.ReadFile(root + "/sys/class/net/" + interface_name + "/iflink")
+		if err != nil {
+			continue
+		}
+		peer, ok := peers[strings.TrimSpace(string(iflink))]
+		if !ok {
+			continue
+		}
+		interface_metrics, err := veth_to_metric(metric_name("network", interface_name), peer)
+		if err != nil {
+			log.Printf("Could not read statistics of %s for interface '%s': %s", peer, interface_name, err)
+			continue
+		}
+		metrics = append(metrics, interface_metrics...)
+	}
+	return metrics, nil
+}
+
+// veth_to_metric reads the statistics of a host side veth peer. Like
+// net_dev_direction, a direction without packets is not reported.
+func veth_to_metric(prefix string, peer string) ([]Metric, error) {
+	values := map[string]string{}
+	for _, stat := range vethStatistics {
+		value, err := ioutil.ReadFile("/sys/class/net/" + peer + "/statistics/" + stat.file)
+		if err != nil {
+			return nil, err
+		}
+		values[stat.metric] = strings.TrimSpace(string(value))
+	}
+
+	var metrics []Metric
+	for _, stat := range vethStatistics {
+		direction := strings.SplitN(stat.metric, ".", 2)[0]
+		if values[direction+".packets"] == "0" {
+			continue
+		}
+		metrics = append(metrics, Metric{prefix + "." + stat.metric, values[stat.metric]})
+	}
+	return metrics, nil
+}
+
+// host_interfaces maps the ifindex of every veth interface in the host network
+// namespace to its name.
+func host_interfaces() (map[string]string, error) {
+	files, err := ioutil.ReadDir("/sys/class/net")
+	if err != nil {
+		return nil, err
+	}
+	interfaces := map[string]string{}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "veth") {
+			continue
+		}
+		ifindex, err := ioutil.ReadFile("/sys/class/net/" + f.Name() + "/ifindex")
+		if err != nil {
+			continue
+		}
+		interfaces[strings.TrimSpace(string(ifindex))] = f.Name()
+	}
+	return interfaces, nil
+}
+
+// net_dev_interfaces returns the interface names listed in /proc/net/dev.
+func net_dev_interfaces(data string) []string {
+	var interfaces []string
+	lines := strings.Split(data, "\n")
+	if len(lines) < 2 {
+		return nil
+	}
+	for _, line := range lines[2:] {
+		split := strings.SplitN(line, ":", 2)
+		if len(split) == 2 {
+			interfaces = append(interfaces, strings.TrimSpace(split[0]))
+		}
+	}
+	return interfaces
+}
+
+// net_dev_to_metric parses the /proc/net/dev format: two header lines
+// followed by "<interface>: <8 receive counters> <8 transmit counters>" for
+// every interface. Directions without any packets are skipped.
+func net_dev_to_metric(prefix string, data string) ([]Metric, error) {
+	var metrics []Metric
+	lines := strings.Split(data, "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("Unexpected network device statistics format")
+	}
+
+	for _, line := range lines[2:] {
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+		interface_name := strings.TrimSpace(split[0])
+		if !interface_included(interface_name) {
+			continue
+		}
+		fields := strings.Fields(split[1])
+		if len(fields) != 16 {
+			log.Printf("Unexpected statistics for interface '%s': %s", interface_name, split[1])
+			continue
+		}
+		name := metric_name(prefix, interface_name)
+
+		metrics = append(metrics, net_dev_direction(name+".rx", fields[0:8], netDevReceive)...)
+		metrics = append(metrics, net_dev_direction(name+".tx", fields[8:16], netDevTransmit)...)
+	}
+	return metrics, nil
+}
+
+func net_dev_direction(prefix string, fields []string, columns map[int]string) []Metric {
+	var metrics []Metric
+	packets, _ := strconv.Atoi(fields[1])
+	if packets == 0 {
+		return nil
+	}
+	for i := range fields {
+		column, ok := columns[i]
+		if ok {
+			metrics = append(metrics, Metric{prefix + "." + column, fields[i]})
+		}
+	}
+	return metrics
+}
+
+// snmpCounters lists the counters from /proc/net/snmp and /proc/net/netstat
+// that are reported, per protocol line, together with the metric prefix.
+var snmpCounters = []struct {
+	proto  string
+	prefix string
+	fields []string
+}{
+	{"Tcp", "network.tcp", []string{"ActiveOpens", "PassiveOpens", "AttemptFails", "EstabResets", "CurrEstab", "InSegs", "OutSegs", "RetransSegs", "InErrs", "OutRsts"}},
+	{"TcpExt", "network.tcp", []string{"ListenOverflows", "ListenDrops", "TCPTimeouts", "TCPAbortOnData", "TCPAbortOnTimeout"}},
+	{"Udp", "network.udp", []string{"InDatagrams", "NoPorts", "InErrors", "OutDatagrams", "RcvbufErrors", "SndbufErrors"}},
+}
+
+// tcpStates maps the hexadecimal socket states used in /proc/net/tcp to their
+// names, see include/net/tcp_states.h.
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+}
+
+// protoStatistics reports the protocol counters of the container's network
+// namespace, and the number of TCP sockets per state unless tcp is nil.
+func (c Container) protoStatistics(tcp []socket) ([]Metric, error) {
+	var metrics []Metric
+
+	counters := map[string]map[string]string{}
+	for _, name := range []string{"net/snmp", "net/netstat"} {
+		filename, err := c.procFile(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		for proto, values := range parse_snmp(string(data)) {
+			counters[proto] = values
+		}
+	}
+	for _, counter := range snmpCounters {
+		values := counters[counter.proto]
+		for _, field := range counter.fields {
+			value, ok := values[field]
+			if ok {
+				metrics = append(metrics, Metric{counter.prefix + "." + field, value})
+			}
+		}
+	}
+
+	filename, err := c.procFile("net/sockstat")
+	if err != nil {
+		return metrics, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return metrics, err
+	}
+	metrics = append(metrics, sockstat_to_metric("network", string(data))...)
+
+	if tcp == nil {
+		return metrics, nil
+	}
+	states := map[string]int{}
+	for _, s := range tcp {
+		states[s.state]++
+	}
+	for _, state := range tcpStates {
+		metrics = append(metrics, Metric{"network.tcp.state." + state, strconv.Itoa(states[state])})
+	}
+
+	return metrics, nil
+}
+
+// parse_snmp parses the /proc/net/snmp and /proc/net/netstat format, where
+// every protocol has a line with field names followed by a line with values:
+//
+//	Tcp: RtoAlgorithm RtoMin ...
+//	Tcp: 1 200 ...
+func parse_snmp(data string) map[string]map[string]string {
+	result := map[string]map[string]string{}
+	header := map[string][]string{}
+	for _, line := range strings.Split(data, "\n") {
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+		proto := split[0]
+		fields := strings.Fields(split[1])
+		names, ok := header[proto]
+		if !ok {
+			header[proto] = fields
+			continue
+		}
+		values := map[string]string{}
+		for i, name := range names {
+			if i < len(fields) {
+				values[name] = fields[i]
+			}
+		}
+		result[proto] = values
+	}
+	return result
+}
+
+// sockstat_to_metric parses the /proc/net/sockstat format, e.g.
+//
+//	TCP: inuse 3 orphan 0 tw 1 alloc 4 mem 1
+func sockstat_to_metric(prefix string, data string) []Metric {
+	var metrics []Metric
+	for _, line := range strings.Split(data, "\n") {
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+		proto := strings.ToLower(split[0])
+		if proto != "tcp" && proto != "udp" {
+			continue
+		}
+		fields := strings.Fields(split[1])
+		for i := 0; i+1 < len(fields); i += 2 {
+			metrics = append(metrics, Metric{metric_name(prefix+"."+proto+".sockets", fields[i]), fields[i+1]})
+		}
+	}
+	return metrics
+}
+
+type socket struct {
+	localPort  int
+	remotePort int
+	state      string
+}
+
+// tcpSockets returns the IPv4 and IPv6 TCP sockets in the container's network
+// namespace, with their state resolved through tcpStates.
+func (c Container) tcpSockets() ([]socket, error) {
+	return c.sockets("net/tcp", "net/tcp6")
+}
+
+func (c Container) sockets(files ...string) ([]socket, error) {
+	// An empty, non-nil result distinguishes a namespace without sockets
+	// from sockets that could not be read
+	sockets := []socket{}
+	for _, name := range files {
+		filename, err := c.procFile(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) && strings.HasSuffix(name, "6") {
+			// IPv6 is disabled
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, parse_sockets(string(data))...)
+	}
+	return sockets, nil
+}
+
+// parse_sockets parses the /proc/net/{tcp,udp}{,6} format:
+//
+//	sl  local_address rem_address   st ...
+//	 0: 00000000:0016 00000000:0000 0A ...
+func parse_sockets(data string) []socket {
+	var sockets []socket
+	lines := strings.Split(data, "\n")
+	if len(lines) < 1 {
+		return nil
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := strings.SplitN(fields[1], ":", 2)
+		remote := strings.SplitN(fields[2], ":", 2)
+		if len(local) != 2 || len(remote) != 2 {
+			continue
+		}
+		localPort, err := strconv.ParseInt(local[1], 16, 32)
+		if err != nil {
+			continue
+		}
+		remotePort, err := strconv.ParseInt(remote[1], 16, 32)
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, socket{int(localPort), int(remotePort), tcpStates[fields[3]]})
+	}
+	return sockets
+}
+
+// ephemeralPortStart is the start of the default Linux ephemeral port range
+// (net.ipv4.ip_local_port_range); remote ports from this range are counted in
+// a single bucket since they identify clients rather than services.
+const ephemeralPortStart = 32768
+
+// connectionStatistics reports the number of established TCP connections per
+// remote port bucket and one series per listening TCP or UDP port. The TCP
+// part is skipped when tcp is nil.
+func (c Container) connectionStatistics(tcp []socket) ([]Metric, error) {
+	var metrics []Metric
+
+	established := 0
+	buckets := map[string]int{}
+	listen := map[int]bool{}
+	for _, s := range tcp {
+		switch s.state {
+		case "established":
+			established++
+			buckets[port_bucket(s.remotePort)]++
+		case "listen":
+			listen[s.localPort] = true
+		}
+	}
+	if tcp != nil {
+		metrics = append(metrics, Metric{"network.connections.established", strconv.Itoa(established)})
+	}
+	for bucket, count := range buckets {
+		metrics = append(metrics, Metric{"network.connections.remote." + bucket, strconv.Itoa(count)})
+	}
+	for port := range listen {
+		metrics = append(metrics, Metric{"network.listen.tcp." + strconv.Itoa(port), "1"})
+	}
+
+	// Unconnected UDP sockets have no remote port and are receiving on
+	// their local port
+	udp, err := c.sockets("net/udp", "net/udp6")
+	if err != nil {
+		return metrics, err
+	}
+	listen = map[int]bool{}
+	for _, s := range udp {
+		if s.remotePort == 0 {
+			listen[s.localPort] = true
+		}
+	}
+	for port := range listen {
+		metrics = append(metrics, Metric{"network.listen.udp." + strconv.Itoa(port), "1"})
+	}
+
+	return metrics, nil
+}
+
+func port_bucket(port int) string {
+	if port >= ephemeralPortStart {
+		return "ephemeral"
+	}
+	return strconv.Itoa(port)
+}
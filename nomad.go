@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type nomadInfo struct {
+	namespace string
+	region    string
+	dc        string
+	job       string
+	jobId     string
+	group     string
+	alloc     string
+	task      string
+}
+
+// Periodic and dispatched (parameterized) jobs get a child job id of the form
+// <parent>/periodic-<timestamp> or <parent>/dispatch-<timestamp>-<uuid prefix>.
+var nomadChildJob = regexp.MustCompile("/(periodic|dispatch)-[0-9]+(-[0-9a-f]{8})?")
+
+// nomad returns the Nomad allocation information from the environment Nomad
+// sets for its tasks. The job is the parent job for periodic and dispatched
+// jobs, and the allocation name has the child job suffix replaced by
+// "-periodic" or "-dispatch", so names are stable across invocations.
+func (c Container) nomad() nomadInfo {
+	env := func(key string) string {
+		return find_value(c.Config.Env, key+"=")
+	}
+
+	n := nomadInfo{
+		namespace: env("NOMAD_NAMESPACE"),
+		region:    env("NOMAD_REGION"),
+		dc:        env("NOMAD_DC"),
+		jobId:     env("NOMAD_JOB_ID"),
+		group:     env("NOMAD_GROUP_NAME"),
+		alloc:     env("NOMAD_ALLOC_NAME"),
+	}
+	if n.alloc == "" {
+		return n
+	}
+	n.alloc = nomadChildJob.ReplaceAllString(n.alloc, "-$1")
+
+	n.job = env("NOMAD_JOB_PARENT_ID")
+	if n.job == "" {
+		n.job = nomadChildJob.ReplaceAllString(env("NOMAD_JOB_ID"), "")
+	}
+	if n.job == "" {
+		n.job = env("NOMAD_JOB_NAME")
+	}
+
+	n.task = strings.TrimPrefix(env("NOMAD_TASK_NAME"), env("NOMAD_JOB_NAME"))
+	n.task = strings.TrimPrefix(n.task, "-")
+	if n.task == "" {
+		n.task = "default"
+	}
+	return n
+}
+
+// tags returns the Nomad tags of the allocation, enriched with the job meta
+// data from the Nomad agent when --nomad-addr is set.
+func (n nomadInfo) tags() map[string]string {
+	tags := map[string]string{}
+	if n.alloc == "" {
+		return tags
+	}
+	for tag, value := range map[string]string{
+		"nomad_namespace": n.namespace,
+		"nomad_region":    n.region,
+		"nomad_dc":        n.dc,
+		"nomad_job":       n.job,
+		"nomad_group":     n.group,
+	} {
+		if value != "" {
+			tags[tag] = value
+		}
+	}
+
+	if nomadAPI != nil && n.job != "" {
+		meta, err := nomadAPI.jobMeta(n.namespace, n.job)
+		if err != nil {
+			log.Printf("Could not get Nomad job %s: %s", n.job, err)
+		}
+		for key, value := range meta {
+			tags["nomad_meta_"+key] = value
+		}
+	}
+	return tags
+}
+
+// nomadAPI is set when a Nomad agent is configured with --nomad-addr.
+var nomadAPI *nomadClient
+
+// nomadClient queries the Nomad agent HTTP API for job meta data, which is
+// cached for cacheTime since it rarely changes. Failed lookups are cached for
+// retryTime, so an unreachable agent does not stall every interval.
+type nomadClient struct {
+	addr      string
+	token     string
+	cacheTime time.Duration
+	retryTime time.Duration
+	client    *http.Client
+
+	lock  sync.Mutex
+	cache map[string]nomadJob
+}
+
+type nomadJob struct {
+	Meta    map[string]string
+	err     error
+	fetched time.Time
+}
+
+// valid returns whether a cached lookup can still be used.
+func (n *nomadClient) valid(job nomadJob) bool {
+	if job.err != nil {
+		return time.Since(job.fetched) < n.retryTime
+	}
+	return time.Since(job.fetched) < n.cacheTime
+}
+
+func new_nomad_client(addr string, token string) *nomadClient {
+	return &nomadClient{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		cacheTime: 5 * time.Minute,
+		retryTime: time.Minute,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		cache:     map[string]nomadJob{},
+	}
+}
+
+func (n *nomadClient) jobMeta(namespace string, id string) (map[string]string, error) {
+	key := namespace + "/" + id
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	job, ok := n.cache[key]
+	if ok && n.valid(job) {
+		return job.Meta, job.err
+	}
+
+	for k, j := range n.cache {
+		if !n.valid(j) {
+			delete(n.cache, k)
+		}
+	}
+
+	job = n.fetch(namespace, id)
+	n.cache[key] = job
+	return job.Meta, job.err
+}
+
+// fetch queries the Nomad agent for a job.
+func (n *nomadClient) fetch(namespace string, id string) nomadJob {
+	job := nomadJob{fetched: time.Now()}
+
+	u := n.addr + "/v1/job/" + url.PathEscape(id)
+	if namespace != "" {
+		u += "?namespace=" + url.QueryEscape(namespace)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		job.err = err
+		return job
+	}
+	if n.token != "" {
+		req.Header.Set("X-Nomad-Token", n.token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		job.err = err
+		return job
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		job.err = fmt.Errorf("Request for %s failed: %s", u, resp.Status)
+		return job
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	if err != nil {
+		job.Meta = nil
+		job.err = err
+	}
+	return job
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNomadInfo(t *testing.T) {
+	tests := []struct {
+		env   []string
+		alloc string
+		job   string
+		task  string
+	}{
+		{
+			[]string{"NOMAD_ALLOC_NAME=web.frontend[0]", "NOMAD_JOB_ID=web", "NOMAD_JOB_NAME=web", "NOMAD_TASK_NAME=web-nginx"},
+			"web.frontend[0]", "web", "nginx",
+		},
+		{
+			[]string{"NOMAD_ALLOC_NAME=backup/periodic-1565353252.backup[0]", "NOMAD_JOB_ID=backup/periodic-1565353252", "NOMAD_JOB_NAME=backup/periodic-1565353252", "NOMAD_TASK_NAME=backup"},
+			"backup-periodic.backup[0]", "backup", "backup",
+		},
+		{
+			[]string{"NOMAD_ALLOC_NAME=render/dispatch-1565353252-0a1b2c3d.render[0]", "NOMAD_JOB_ID=render/dispatch-1565353252-0a1b2c3d", "NOMAD_JOB_PARENT_ID=render", "NOMAD_JOB_NAME=render/dispatch-1565353252-0a1b2c3d", "NOMAD_TASK_NAME=worker"},
+			"render-dispatch.render[0]", "render", "worker",
+		},
+		{
+			[]string{"NOMAD_ALLOC_NAME=api.api[1]", "NOMAD_JOB_NAME=api", "NOMAD_TASK_NAME=api"},
+			"api.api[1]", "api", "default",
+		},
+	}
+
+	for _, test := range tests {
+		n := Container{Config: ContainerConfig{Env: test.env}}.nomad()
+		if n.alloc != test.alloc || n.job != test.job || n.task != test.task {
+			t.Errorf("nomad() of %v = %q, %q, %q, want %q, %q, %q", test.env, n.alloc, n.job, n.task, test.alloc, test.job, test.task)
+		}
+	}
+}
+
+func TestNomadJobMeta(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.EscapedPath() != "/v1/job/render" {
+			t.Errorf("Unexpected path %s", r.URL.EscapedPath())
+		}
+		if r.URL.Query().Get("namespace") != "batch" {
+			t.Errorf("Unexpected namespace %q", r.URL.Query().Get("namespace"))
+		}
+		if r.Header.Get("X-Nomad-Token") != "secret" {
+			t.Errorf("Unexpected token %q", r.Header.Get("X-Nomad-Token"))
+		}
+		w.Write([]byte(`{"ID": "render", "Meta": {"team": "graphics"}}`))
+	}))
+	defer server.Close()
+
+	client := new_nomad_client(server.URL+"/", "secret")
+	for i := 0; i < 2; i++ {
+		meta, err := client.jobMeta("batch", "render")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta["team"] != "graphics" {
+			t.Errorf("jobMeta() = %v, want team=graphics", meta)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Nomad agent was queried %d times, want 1", requests)
+	}
+}
+
+func TestNomadJobMetaError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "job not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := new_nomad_client(server.URL, "")
+	for i := 0; i < 2; i++ {
+		_, err := client.jobMeta("", "missing")
+		if err == nil {
+			t.Error("jobMeta() of a missing job did not return an error")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Nomad agent was queried %d times, want 1", requests)
+	}
+}
+
+func TestNomadTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/v1/job/web" {
+			t.Errorf("Unexpected path %s", r.URL.EscapedPath())
+		}
+		w.Write([]byte(`{"Meta": {"team": "web"}}`))
+	}))
+	defer server.Close()
+
+	nomadAPI = new_nomad_client(server.URL, "")
+	defer func() { nomadAPI = nil }()
+
+	c := Container{Config: ContainerConfig{Env: []string{
+		"NOMAD_ALLOC_NAME=web/dispatch-1565353252-0a1b2c3d.frontend[0]",
+		"NOMAD_JOB_ID=web/dispatch-1565353252-0a1b2c3d",
+		"NOMAD_JOB_PARENT_ID=web",
+		"NOMAD_JOB_NAME=web/dispatch-1565353252-0a1b2c3d",
+		"NOMAD_GROUP_NAME=frontend",
+		"NOMAD_NAMESPACE=default",
+		"NOMAD_DC=dc1",
+		"NOMAD_REGION=global",
+	}}}
+	tags := c.nomad().tags()
+	expected := map[string]string{
+		"nomad_namespace": "default",
+		"nomad_region":    "global",
+		"nomad_dc":        "dc1",
+		"nomad_job":       "web",
+		"nomad_group":     "frontend",
+		"nomad_meta_team": "web",
+	}
+	for tag, value := range expected {
+		if tags[tag] != value {
+			t.Errorf("tags()[%s] = %q, want %q", tag, tags[tag], value)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// process holds the counters of a single process, or the aggregate of all
+// processes sharing a command name.
+type process struct {
+	comm       string
+	count      int
+	utime      int64
+	stime      int64
+	threads    int64
+	rss        int64
+	readBytes  int64
+	writeBytes int64
+}
+
+func (p *process) add(o process) {
+	p.count += o.count
+	p.utime += o.utime
+	p.stime += o.stime
+	p.threads += o.threads
+	p.rss += o.rss
+	p.readBytes += o.readBytes
+	p.writeBytes += o.writeBytes
+}
+
+func (p process) metrics(prefix string) []Metric {
+	return []Metric{
+		{prefix + ".count", strconv.Itoa(p.count)},
+		{prefix + ".cpu.user", strconv.FormatInt(p.utime, 10)},
+		{prefix + ".cpu.system", strconv.FormatInt(p.stime, 10)},
+		{prefix + ".threads", strconv.FormatInt(p.threads, 10)},
+		{prefix + ".memory.rss", strconv.FormatInt(p.rss, 10)},
+		{prefix + ".io.read_bytes", strconv.FormatInt(p.readBytes, 10)},
+		{prefix + ".io.write_bytes", strconv.FormatInt(p.writeBytes, 10)},
+	}
+}
+
+// processes returns the pids of the processes in the container's cgroup.
+func (c Container) processes() ([]string, error) {
+	data, err := ioutil.ReadFile(c.pidsFile("cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		pid := strings.TrimSpace(line)
+		if pid != "" {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// processNames remembers, per container, the command names that are reported
+// separately. Names are kept once selected, so their cumulative counters do
+// not move between their own series and "other".
+var processNames = map[string]*reportedNames{}
+
+type reportedNames struct {
+	names map[string]bool
+	seen  time.Time
+}
+
+// reported_names returns the reported command names of a container, and
+// forgets the names of containers that were not seen for an hour.
+func reported_names(id string) map[string]bool {
+	for other, r := range processNames {
+		if time.Since(r.seen) > time.Hour {
+			delete(processNames, other)
+		}
+	}
+	r, ok := processNames[id]
+	if !ok {
+		r = &reportedNames{names: map[string]bool{}}
+		processNames[id] = r
+	}
+	r.seen = time.Now()
+	return r.names
+}
+
+// processMetrics aggregates the processes in the container's cgroup by
+// command name. The first --process-names names that are seen are reported
+// separately, when several new names appear at once those using the most CPU
+// are selected first. The remaining processes are reported as "other".
+func (c Container) processMetrics() []Metric {
+	pids, err := c.processes()
+	if err != nil {
+		return nil
+	}
+
+	byComm := map[string]*process{}
+	for _, pid := range pids {
+		p, err := read_process(pid)
+		if err != nil {
+			// The process may have exited in the meantime
+			if *Debug {
+				log.Printf("Could not read process %s: %s", pid, err)
+			}
+			continue
+		}
+		agg, ok := byComm[p.comm]
+		if !ok {
+			agg = &process{comm: p.comm}
+			byComm[p.comm] = agg
+		}
+		agg.add(p)
+	}
+
+	var procs []*process
+	for _, p := range byComm {
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		ti := procs[i].utime + procs[i].stime
+		tj := procs[j].utime + procs[j].stime
+		if ti != tj {
+			return ti > tj
+		}
+		return procs[i].comm < procs[j].comm
+	})
+
+	names := reported_names(c.Id)
+	var metrics []Metric
+	other := process{}
+	for _, p := range procs {
+		if !names[p.comm] && len(names) < *ProcessNames {
+			names[p.comm] = true
+		}
+		if names[p.comm] {
+			metrics = append(metrics, p.metrics(metric_name("process", p.comm))...)
+		} else {
+			other.add(*p)
+		}
+	}
+	if other.count > 0 {
+		metrics = append(metrics, other.metrics("process.other")...)
+	}
+	return metrics
+}
+
+// read_process reads the counters of a process from /proc/<pid>/stat, status
+// and io. The io file is only readable with sufficient privileges, so its
+// counters are left at zero when it can not be read.
+func read_process(pid string) (process, error) {
+	p := process{count: 1}
+
+	stat, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		return p, err
+	}
+	// The command name is enclosed in parentheses and may contain spaces
+	s := string(stat)
+	start := strings.Index(s, "(")
+	end := strings.LastIndex(s, ")")
+	if start < 0 || end < start {
+		return p, fmt.Errorf("Unexpected format of /proc/%s/stat", pid)
+	}
+	p.comm = s[start+1 : end]
+	// Fields after the command name, starting with field 3 (state)
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 18 {
+		return p, fmt.Errorf("Unexpected format of /proc/%s/stat", pid)
+	}
+	p.utime, _ = strconv.ParseInt(fields[11], 10, 64)
+	p.stime, _ = strconv.ParseInt(fields[12], 10, 64)
+	p.threads, _ = strconv.ParseInt(fields[17], 10, 64)
+
+	status := grep("^VmRSS:", "/proc/"+pid+"/status")
+	if status != "" {
+		split := strings.Fields(status)
+		if len(split) >= 2 {
+			kb, _ := strconv.ParseInt(split[1], 10, 64)
+			p.rss = kb * 1024
+		}
+	}
+
+	io, err := ioutil.ReadFile("/proc/" + pid + "/io")
+	if err == nil {
+		for _, line := range strings.Split(string(io), "\n") {
+			split := strings.SplitN(line, ":", 2)
+			if len(split) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseInt(strings.TrimSpace(split[1]), 10, 64)
+			switch split[0] {
+			case "read_bytes":
+				p.readBytes = value
+			case "write_bytes":
+				p.writeBytes = value
+			}
+		}
+	}
+
+	return p, nil
+}
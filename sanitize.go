@@ -0,0 +1,134 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// sanitizer cleans metric paths for a specific backend. Metric paths are
+// handled internally as components joined by dots; every component that is
+// not a fixed string is passed through Component, which must remove dots, and
+// the final path is produced by Path.
+type sanitizer interface {
+	// Component returns s with all characters that are not allowed in a
+	// single path component replaced.
+	Component(s string) string
+	// Path joins sanitized components into a metric path.
+	Path(components []string) string
+}
+
+var sanitizers = map[string]sanitizer{
+	"graphite":   graphiteSanitizer{},
+	"prometheus": prometheusSanitizer{},
+	"influxdb":   influxdbSanitizer{},
+}
+
+// active_sanitizer returns the sanitizer selected with --sanitizer, which
+// defaults to Graphite.
+func active_sanitizer() sanitizer {
+	s, ok := sanitizers[*Sanitizer]
+	if !ok {
+		return graphiteSanitizer{}
+	}
+	return s
+}
+
+var (
+	graphiteIllegal   = regexp.MustCompile("[^A-Za-z0-9_-]+")
+	prometheusIllegal = regexp.MustCompile("[^A-Za-z0-9_]+")
+	influxdbIllegal   = regexp.MustCompile(`[.\\\s,=\x00-\x1f]+`)
+	repeatedUnderline = regexp.MustCompile("__+")
+)
+
+// replace_component replaces the illegal characters in a component by an
+// underscore, collapses repeated underscores and trims them from both ends.
+// A component is never empty, so the number of path levels is preserved.
+func replace_component(illegal *regexp.Regexp, s string) string {
+	s = illegal.ReplaceAllString(s, "_")
+	s = repeatedUnderline.ReplaceAllString(s, "_")
+	s = strings.Trim(s, "_")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+// graphiteSanitizer produces dot separated paths, with components restricted
+// to letters, digits, underscores and dashes.
+type graphiteSanitizer struct{}
+
+func (graphiteSanitizer) Component(s string) string {
+	return replace_component(graphiteIllegal, s)
+}
+
+func (graphiteSanitizer) Path(components []string) string {
+	return strings.Join(components, ".")
+}
+
+// prometheusSanitizer produces metric names matching [a-zA-Z_:][a-zA-Z0-9_:]*,
+// with components joined by underscores. Colons are reserved for recording
+// rules and are not used.
+type prometheusSanitizer struct{}
+
+func (prometheusSanitizer) Component(s string) string {
+	return replace_component(prometheusIllegal, s)
+}
+
+func (prometheusSanitizer) Path(components []string) string {
+	path := strings.Join(components, "_")
+	path = repeatedUnderline.ReplaceAllString(path, "_")
+	if path == "" || (path[0] >= '0' && path[0] <= '9') {
+		path = "_" + path
+	}
+	return path
+}
+
+// influxdbSanitizer produces dot separated paths for the InfluxDB Graphite
+// input. Whitespace separates the fields of a plaintext line, and commas and
+// equal signs delimit tags in InfluxDB, so they are replaced as well.
+type influxdbSanitizer struct{}
+
+func (influxdbSanitizer) Component(s string) string {
+	return replace_component(influxdbIllegal, s)
+}
+
+func (influxdbSanitizer) Path(components []string) string {
+	return strings.Join(components, ".")
+}
+
+// metric_name appends sanitized components to a fixed, dot separated prefix.
+func metric_name(prefix string, components ...string) string {
+	s := active_sanitizer()
+	name := prefix
+	for _, component := range components {
+		if name != "" {
+			name += "."
+		}
+		name += s.Component(component)
+	}
+	return name
+}
+
+// sanitize_name sanitizes every dot separated component of a name.
+func sanitize_name(name string) string {
+	s := active_sanitizer()
+	components := strings.Split(name, ".")
+	for i := range components {
+		components[i] = s.Component(components[i])
+	}
+	return strings.Join(components, ".")
+}
+
+// prefix_name prepends the sanitized --prefix to a dot separated name.
+func prefix_name(name string) string {
+	if *GraphitePrefix == "" {
+		return name
+	}
+	return sanitize_name(*GraphitePrefix) + "." + name
+}
+
+// metric_path converts a dot separated name, with sanitized components, to
+// the metric path of the backend.
+func metric_path(name string) string {
+	return active_sanitizer().Path(strings.Split(name, "."))
+}
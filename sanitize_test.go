@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSanitizerComponent(t *testing.T) {
+	tests := []struct {
+		input      string
+		graphite   string
+		prometheus string
+		influxdb   string
+	}{
+		{"eth0", "eth0", "eth0", "eth0"},
+		{"eth0.100", "eth0_100", "eth0_100", "eth0_100"},
+		{"mapper/vg0-root", "mapper_vg0-root", "mapper_vg0_root", "mapper/vg0-root"},
+		{"my container", "my_container", "my_container", "my_container"},
+		{"a,b=c", "a_b_c", "a_b_c", "a_b_c"},
+		{"grp[0]", "grp_0", "grp_0", "grp[0]"},
+		{"__a__b__", "a_b", "a_b", "a_b"},
+		{"café", "caf", "caf", "café"},
+		{"..", "_", "_", "_"},
+		{"", "_", "_", "_"},
+		{"line\nbreak", "line_break", "line_break", "line_break"},
+		{"C:\\temp", "C_temp", "C_temp", "C:_temp"},
+	}
+
+	for _, test := range tests {
+		for name, expected := range map[string]string{
+			"graphite":   test.graphite,
+			"prometheus": test.prometheus,
+			"influxdb":   test.influxdb,
+		} {
+			result := sanitizers[name].Component(test.input)
+			if result != expected {
+				t.Errorf("%s Component(%q) = %q, want %q", name, test.input, result, expected)
+			}
+		}
+	}
+}
+
+func TestSanitizerPath(t *testing.T) {
+	tests := []struct {
+		sanitizer  string
+		components []string
+		expected   string
+	}{
+		{"graphite", []string{"random", "web", "cpu", "user"}, "random.web.cpu.user"},
+		{"prometheus", []string{"random", "web", "cpu", "user"}, "random_web_cpu_user"},
+		{"prometheus", []string{"1st", "cpu"}, "_1st_cpu"},
+		{"prometheus", []string{"_", "cpu"}, "_cpu"},
+		{"influxdb", []string{"random", "my-web", "cpu"}, "random.my-web.cpu"},
+	}
+
+	for _, test := range tests {
+		result := sanitizers[test.sanitizer].Path(test.components)
+		if result != test.expected {
+			t.Errorf("%s Path(%q) = %q, want %q", test.sanitizer, test.components, result, test.expected)
+		}
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		prefix     string
+		components []string
+		expected   string
+	}{
+		{"network", []string{"eth0.100"}, "network.eth0_100"},
+		{"blkio.throttle.io_serviced", []string{"dm-0", "Read"}, "blkio.throttle.io_serviced.dm-0.Read"},
+		{"process", []string{"(sd-pam)"}, "process.sd-pam"},
+		{"", []string{"a.b", "c"}, "a_b.c"},
+	}
+
+	for _, test := range tests {
+		result := metric_name(test.prefix, test.components...)
+		if result != test.expected {
+			t.Errorf("metric_name(%q, %q) = %q, want %q", test.prefix, test.components, result, test.expected)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"random./web.main.host", "random.web.main.host"},
+		{"nomad.job-periodic.grp[0].task", "nomad.job-periodic.grp_0.task"},
+		{"registrator.my web.prod.host", "registrator.my_web.prod.host"},
+		{"random.a..b", "random.a._.b"},
+	}
+
+	for _, test := range tests {
+		result := sanitize_name(test.input)
+		if result != test.expected {
+			t.Errorf("sanitize_name(%q) = %q, want %q", test.input, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// UnmarshalJSON accepts both the state object returned by the inspect call and
+// the plain state string ("running", "exited", ...) returned when listing
+// containers.
+func (s *ContainerState) UnmarshalJSON(data []byte) error {
+	var status string
+	if json.Unmarshal(data, &status) == nil {
+		*s = ContainerState{Status: status, Running: status == "running"}
+		return nil
+	}
+
+	type state ContainerState
+	return json.Unmarshal(data, (*state)(s))
+}
+
+// UnmarshalJSON accepts both the RFC 3339 timestamp returned by the inspect
+// call and the seconds since the epoch returned when listing containers.
+func (t *ContainerCreated) UnmarshalJSON(data []byte) error {
+	var seconds int64
+	if json.Unmarshal(data, &seconds) == nil {
+		*t = ContainerCreated(seconds)
+		return nil
+	}
+
+	var timestamp string
+	err := json.Unmarshal(data, &timestamp)
+	if err != nil {
+		return err
+	}
+	created, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return err
+	}
+	*t = ContainerCreated(created.Unix())
+	return nil
+}
+
+// healthStatus maps the Docker health check states to numeric values, using
+// the usual monitoring convention where 0 is OK and 2 is critical.
+var healthStatus = map[string]int{
+	"healthy":   0,
+	"starting":  1,
+	"unhealthy": 2,
+}
+
+func bool_value(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (c Container) stateMetrics() []Metric {
+	metrics := []Metric{
+		{"state.running", bool_value(c.State.Running)},
+		{"state.paused", bool_value(c.State.Paused)},
+		{"state.restarting", bool_value(c.State.Restarting)},
+		{"state.oom_killed", bool_value(c.State.OOMKilled)},
+		{"state.restart_count", strconv.Itoa(c.RestartCount)},
+		{"state.exit_code", strconv.Itoa(c.State.ExitCode)},
+	}
+
+	if c.State.Running {
+		started, err := time.Parse(time.RFC3339Nano, c.State.StartedAt)
+		if err == nil {
+			uptime := int64(time.Since(started).Seconds())
+			metrics = append(metrics, Metric{"state.uptime_seconds", strconv.FormatInt(uptime, 10)})
+		}
+	}
+
+	if c.State.Health != nil {
+		status, ok := healthStatus[c.State.Health.Status]
+		if ok {
+			metrics = append(metrics, Metric{"health.status", strconv.Itoa(status)})
+		}
+		metrics = append(metrics, Metric{"health.failing_streak", strconv.Itoa(c.State.Health.FailingStreak)})
+	}
+
+	return metrics
+}
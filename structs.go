@@ -1,16 +1,26 @@
 package main
 
 type Container struct {
-	Command string
-	Created int
-	Id      string
-	Image   string
-	Name    string
-	Ports   []ContainerPort
-	Status  string
-	Config  ContainerConfig
+	Command      string
+	Created      ContainerCreated
+	Id           string
+	Image        string
+	Name         string
+	Ports        []ContainerPort
+	Status       string
+	Config       ContainerConfig
+	HostConfig   ContainerHostConfig
+	State        ContainerState
+	RestartCount int
+	Mounts       []ContainerMount
+	SizeRw       int64
+	SizeRootFs   int64
 }
 
+// ContainerCreated is the creation time of a container, in seconds since the
+// epoch.
+type ContainerCreated int64
+
 type ContainerPort struct {
 	IP          string
 	PrivatePort int
@@ -18,8 +28,38 @@ type ContainerPort struct {
 	Type        string
 }
 
+type ContainerMount struct {
+	Type        string
+	Name        string
+	Source      string
+	Destination string
+}
+
 type ContainerConfig struct {
-	Env []string
+	Env    []string
+	Image  string
+	Labels map[string]string
+}
+
+type ContainerHostConfig struct {
+	NetworkMode string
+}
+
+type ContainerState struct {
+	Status     string
+	Running    bool
+	Paused     bool
+	Restarting bool
+	OOMKilled  bool
+	ExitCode   int
+	StartedAt  string
+	FinishedAt string
+	Health     *ContainerHealth
+}
+
+type ContainerHealth struct {
+	Status        string
+	FailingStreak int
 }
 
 type Metric struct {
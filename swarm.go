@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+)
+
+type swarmInfo struct {
+	stack   string
+	service string
+	slot    string
+}
+
+// swarm returns the service information of Docker Swarm tasks. Task names
+// are <service>.<slot>.<taskid> for replicated services and
+// <service>.<nodeid>.<taskid> for global services, so the slot is stable
+// across task replacements.
+func (c Container) swarm() swarmInfo {
+	labels := c.Config.Labels
+	s := swarmInfo{
+		stack:   labels["com.docker.stack.namespace"],
+		service: labels["com.docker.swarm.service.name"],
+	}
+	if s.service == "" {
+		return s
+	}
+
+	task := strings.TrimPrefix(labels["com.docker.swarm.task.name"], s.service+".")
+	s.slot = strings.SplitN(task, ".", 2)[0]
+	if s.slot == "" || s.slot == labels["com.docker.swarm.task.id"] {
+		s.slot = labels["com.docker.swarm.node.id"]
+	}
+
+	if s.stack != "" {
+		s.service = strings.TrimPrefix(s.service, s.stack+"_")
+	}
+	return s
+}
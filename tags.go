@@ -0,0 +1,74 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelTags are the well-known labels that are always turned into tags when
+// present: Docker Compose, Docker Swarm and the OCI image annotations.
+var labelTags = map[string]string{
+	"compose_project": "com.docker.compose.project",
+	"compose_service": "com.docker.compose.service",
+	"compose_number":  "com.docker.compose.container-number",
+	"swarm_node":      "com.docker.swarm.node.id",
+	"image_title":     "org.opencontainers.image.title",
+	"image_version":   "org.opencontainers.image.version",
+	"image_revision":  "org.opencontainers.image.revision",
+	"image_source":    "org.opencontainers.image.source",
+	"image_vendor":    "org.opencontainers.image.vendor",
+}
+
+// Tags returns the tags of the container, from the well-known labels, the
+// Nomad allocation, the owner of a shared network namespace and the labels
+// given with --tag-label.
+func (c Container) Tags() map[string]string {
+	tags := map[string]string{}
+	for tag, label := range labelTags {
+		value, ok := c.Config.Labels[label]
+		if ok && value != "" {
+			tags[tag] = value
+		}
+	}
+	for tag, value := range c.nomad().tags() {
+		tags[tag] = value
+	}
+	if owner := network_owner(c.Id); owner != "" {
+		tags["network_owner"] = owner
+	}
+	for tag, label := range *TagLabels {
+		value, ok := c.Config.Labels[label]
+		if ok && value != "" {
+			tags[tag] = value
+		}
+	}
+	return tags
+}
+
+// stripTagName and stripTagValue match the characters that are not allowed
+// in Graphite tag names and values. Tag names come from Nomad job meta keys
+// and --tag-label, so they are not under our control either.
+var (
+	stripTagName  = regexp.MustCompile(`[;!^=]+|[^\x21-\x7e]+`)
+	stripTagValue = regexp.MustCompile("[;~!^= \t\n]+")
+)
+
+// format_tags formats tags as a Graphite tag suffix, ";tag1=value1;tag2=value2",
+// sorted by tag name.
+func format_tags(tags map[string]string) string {
+	var names []string
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		b.WriteString(";" + stripTagName.ReplaceAllString(name, "_") + "=" + stripTagValue.ReplaceAllString(tags[name], "_"))
+	}
+	return b.String()
+}